@@ -4,12 +4,20 @@ import (
 	Form_Mailly_Go "Form-Mailly-Go"
 	"Form-Mailly-Go/internal/config"
 	"Form-Mailly-Go/internal/handler"
+	"Form-Mailly-Go/internal/idempotency"
+	"Form-Mailly-Go/internal/service"
+	"Form-Mailly-Go/internal/template"
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"runtime"
 	"runtime/debug"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -23,6 +31,10 @@ func init() {
 	}
 	// Safely load environment variables
 	config.LoadEnvironmentVariable()
+	if dir := config.EnvVar.TemplateDir; dir != "" {
+		template.LogReload(template.LoadDir(dir))
+	}
+	service.CheckTemplateSettings()
 }
 
 func main() {
@@ -33,26 +45,98 @@ func main() {
 	mux.HandleFunc("GET /api/health", handler.HealthHandler)
 	mux.HandleFunc("GET /api/runtime-info", handler.RuntimeInfoHandler)
 	mux.HandleFunc("GET /api/metrics", handler.MetricsHandler)
+	mux.HandleFunc("GET /api/templates", handler.TemplatesHandler)
+	mux.HandleFunc("GET /api/templates/{name}/preview", handler.TemplatePreviewHandler)
+
+	// Retries carrying the same Idempotency-Key replay the first response instead of sending again
+	idempotent := idempotency.NewStore(config.EnvVar.IdempotencyTTL, config.EnvVar.IdempotencyMaxBytes).Middleware
+	mux.Handle("POST /api/contact", idempotent(http.HandlerFunc(handler.ContactHandler)))
+	mux.Handle("POST /api/batch/contact", idempotent(http.HandlerFunc(handler.BatchEmailProcessor)))
+	mux.HandleFunc("GET /api/batch/{id}/report", handler.BatchReportHandler)
 
-	mux.HandleFunc("POST /api/contact", handler.ContactHandler)
-	mux.HandleFunc("POST /api/batch/contact", handler.BatchEmailProcessor)
+	// Requests inherit this context, so cancelling it tells running batches to wrap up
+	baseCtx, cancelRequests := context.WithCancelCause(context.Background())
 
 	server := &http.Server{
-		Addr:        ":8080",
-		Handler:     securityHeadersMiddleware(mux),
-		ReadTimeout: 10 * time.Second,
-		//WriteTimeout: 30 * time.Second,
-		IdleTimeout: 60 * time.Second,
-		//MaxHeaderBytes: 1 << 20, // 1MB
+		Addr:           ":8080",
+		Handler:        securityHeadersMiddleware(mux),
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   config.EnvVar.WriteTimeout, // Batch streams clear it for themselves
+		IdleTimeout:    60 * time.Second,
+		MaxHeaderBytes: 1 << 20, // 1MB
+		BaseContext:    func(net.Listener) context.Context { return baseCtx },
 	}
 
 	// simulateLambdaLimits()
 
+	// Custom templates are picked up when their files change, or right away on SIGHUP
+	if config.EnvVar.TemplateDir != "" {
+		template.WatchDir(config.EnvVar.TemplatePoll)
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		go func() {
+			for range reload {
+				template.LogReload(template.Reload())
+			}
+		}()
+	}
+
 	// Start server
-	fmt.Println("Starting server on port 8080")
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("Server failed: %v", err)
+	serverErr := make(chan error, 1)
+	go func() {
+		fmt.Println("Starting server on port 8080")
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// Wait for Ctrl-C / deploy signal, or for the server to fail on its own
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	select {
+	case err := <-serverErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+		return
+	case <-signalCtx.Done():
+		stopSignals() // A second signal kills the process immediately
+	}
+
+	gracefulShutdown(server, cancelRequests)
+}
+
+// gracefulShutdown stops accepting connections and lets in-flight requests,
+// including running batches and those reporting through a webhook, finish
+// within SHUTDOWN_TIMEOUT. Batches still running after that stop starting new
+// emails, report a final summary and quit their SMTP connections before the
+// server closes.
+func gracefulShutdown(server *http.Server, cancelRequests context.CancelCauseFunc) {
+	const summaryGrace = 10 * time.Second // Time for interrupted batches to finish their current email
+
+	fmt.Printf("Shutting down: waiting up to %v for in-flight requests\n", config.EnvVar.ShutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), config.EnvVar.ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(drainCtx)
+	if err == nil {
+		err = handler.WaitDetached(drainCtx)
+	}
+	if err != nil {
+		fmt.Println("Shutdown deadline reached: interrupting running batches")
+		cancelRequests(handler.ErrServerShutdown)
+		handler.InterruptDetached()
+
+		graceCtx, cancelGrace := context.WithTimeout(context.Background(), summaryGrace)
+		defer cancelGrace()
+		if err := server.Shutdown(graceCtx); err != nil {
+			_ = server.Close()
+		}
+		if err := handler.WaitDetached(graceCtx); err != nil {
+			fmt.Println("Background batches did not finish in time")
+		}
 	}
+	service.FlushSendQuota() // Keep today's usage for the next start
+	fmt.Println("Server stopped")
 }
 
 // securityHeadersMiddleware adds essential security headers to all responses
@@ -74,7 +158,8 @@ func securityHeadersMiddleware(next http.Handler) http.Handler {
 		// CORS headers for cross-origin requests
 		headers.Set("Access-Control-Allow-Origin", "*")
 		headers.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		headers.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, Accept-Language, Last-Event-ID")
+		headers.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, Accept-Language, Last-Event-ID, Idempotency-Key")
+		headers.Set("Access-Control-Expose-Headers", "Idempotent-Replayed, Retry-After, X-Batch-ID")
 		headers.Set("Content-Type", "application/json")
 
 		headers.Add("Vary", "Origin")
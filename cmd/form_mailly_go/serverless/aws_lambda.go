@@ -4,6 +4,9 @@ import (
 	Form_Mailly_Go "Form-Mailly-Go"
 	"Form-Mailly-Go/internal/config"
 	"Form-Mailly-Go/internal/handler"
+	"Form-Mailly-Go/internal/idempotency"
+	"Form-Mailly-Go/internal/service"
+	"Form-Mailly-Go/internal/template"
 	"net/http"
 
 	"github.com/aws/aws-lambda-go/lambda"
@@ -15,12 +18,16 @@ import (
 // zip -r lambda-handler.zip bootstrap public/index.html
 
 func main() {
+	// Safely load environment variables (routes depend on the configuration)
+	config.LoadEnvironmentVariable()
+	if dir := config.EnvVar.TemplateDir; dir != "" {
+		template.LogReload(template.LoadDir(dir)) // Read once: instances are replaced on deploy
+	}
+	service.CheckTemplateSettings()
+
 	// Setup HTTP routes
 	router := setupRoutes()
 
-	// Safely load environment variables
-	config.LoadEnvironmentVariable()
-
 	// Start Lambda handler with the configured router
 	lambda.Start(httpadapter.NewV2(router).ProxyWithContext)
 }
@@ -37,11 +44,19 @@ func setupRoutes() http.Handler {
 	mux.HandleFunc("GET /api/health", handler.HealthHandler)
 	mux.HandleFunc("GET /api/runtime-info", handler.RuntimeInfoHandler)
 	mux.HandleFunc("GET /api/metrics", handler.MetricsHandler)
+	// Email templates contact submissions can choose from
+	mux.HandleFunc("GET /api/templates", handler.TemplatesHandler)
+	mux.HandleFunc("GET /api/templates/{name}/preview", handler.TemplatePreviewHandler)
+
+	// Retries carrying the same Idempotency-Key replay the first response instead of sending again
+	idempotent := idempotency.NewStore(config.EnvVar.IdempotencyTTL, config.EnvVar.IdempotencyMaxBytes).Middleware
 
 	// For sending individual emails
-	mux.HandleFunc("POST /api/contact", handler.ContactHandler)
+	mux.Handle("POST /api/contact", idempotent(http.HandlerFunc(handler.ContactHandler)))
 	// For sending multiple emails efficiently
-	mux.HandleFunc("POST /api/batch/contact", handler.BatchEmailProcessor)
+	mux.Handle("POST /api/batch/contact", idempotent(http.HandlerFunc(handler.BatchEmailProcessor)))
+	// Per-recipient results of a finished (or running) batch, as JSON or CSV
+	mux.HandleFunc("GET /api/batch/{id}/report", handler.BatchReportHandler)
 
 	// Apply security middleware to all routes
 	return applySecurityHeaders(mux)
@@ -66,7 +81,8 @@ func applySecurityHeaders(next http.Handler) http.Handler {
 		// CORS headers for cross-origin requests
 		headers.Set("Access-Control-Allow-Origin", "*")
 		headers.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		headers.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, Accept-Language, Last-Event-ID")
+		headers.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, Accept-Language, Last-Event-ID, Idempotency-Key")
+		headers.Set("Access-Control-Expose-Headers", "Idempotent-Replayed, Retry-After, X-Batch-ID")
 		headers.Set("Content-Type", "application/json")
 
 		headers.Add("Vary", "Origin")
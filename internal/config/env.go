@@ -4,6 +4,10 @@ import (
 	"Form-Mailly-Go/internal/validation"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Lambda and slim images may lack a zoneinfo database for TIMEZONE
 )
 
 // EnvironmentVariable holds all configuration needed for service sending
@@ -13,6 +17,64 @@ type EnvironmentVariable struct {
 	ReceiverEmail  string // Default recipient service (can be overridden in batch)
 	SMTPHost       string // SMTP server hostname
 	SMTPPort       string // SMTP server port
+
+	// Contact mail templates
+	ContactTemplate string                  // Template used when neither the payload nor the form names one
+	Forms           map[string]FormSettings // Per-form settings by product name, from FORMS_FILE
+	TemplateDir     string                  // Directory of custom templates, empty for the built-in ones only
+	TemplatePoll    time.Duration           // How often TEMPLATE_DIR is checked for changes
+	AutoReplyLimit  int                     // Auto-replies one address may receive per AutoReplyWindow
+	AutoReplyWindow time.Duration
+	DefaultLocale   string         // Locale emails fall back to before English
+	Timezone        *time.Location // Where times in emails are shown unless the form has its own
+
+	// Batch delivery
+	DedupeProviderRules  bool // Also fold provider aliases (Gmail dots, +tags) when removing duplicate recipients
+	BatchWorkersPerBatch int  // Upper bound for the workers of each batch; SMTPMaxConnections bounds them all
+
+	// Batch size limits and backpressure. Zero means unlimited.
+	BatchMaxEntries      int // Entries accepted in one batch
+	BatchMaxBodyBytes    int // Size of a batch request body
+	BatchMaxMessageBytes int // Size of one entry's message
+	BatchMaxInFlight     int // Batches running at once, including those reporting through callback_url
+
+	// Per recipient domain delivery limits within a batch
+	DomainDefaultLimit DomainLimit            // Applied to every domain without an override
+	DomainLimits       map[string]DomainLimit // Overrides by domain, e.g. outlook.com
+
+	// SMTP connection sharing
+	SMTPMaxConnections int           // SMTP connections open at once across all batches and contact sends
+	SMTPMaxQueue       int           // Senders allowed to wait for a free connection
+	SMTPQueueTimeout   time.Duration // How long a sender waits before getting 429
+
+	// SMTP circuit breaker
+	SMTPBreakerThreshold int           // Consecutive connection failures that open the circuit
+	SMTPBreakerCooldown  time.Duration // How long sends fail fast before a probe connection
+
+	// Connections each priority class may hold; 0 picks the default
+	SMTPBulkConnections   int // Bulk batches, by default all connections but one
+	SMTPNormalConnections int // Batches sent with priority=normal, by default all connections
+
+	// Outbound send quota per sender profile, matching the provider's limits. Zero means unlimited.
+	SendRatePerSecond int
+	SendRatePerMinute int
+	SendRatePerDay    int
+	SendQuotaFile     string // Where quota usage is kept across restarts; empty keeps it in memory only
+
+	// Batch completion webhooks
+	WebhookSecret      string        // Shared secret signing callback_url deliveries
+	WebhookMaxAttempts int           // Deliveries tried before giving up
+	WebhookTimeout     time.Duration // Limit for a single delivery attempt
+
+	// Batch reports
+	ReportDir       string        // Where per-recipient batch results are kept; empty disables reports
+	ReportRetention time.Duration // How long reports are kept
+
+	// Request handling
+	IdempotencyTTL      time.Duration // How long Idempotency-Key outcomes are replayed
+	IdempotencyMaxBytes int           // Memory for stored outcomes; the least recently used go first beyond it
+	ShutdownTimeout     time.Duration // How long in-flight requests may run after SIGINT/SIGTERM
+	WriteTimeout        time.Duration // Limit for writing a response; batch streams and report downloads are exempt
 }
 
 var EnvVar *EnvironmentVariable
@@ -32,6 +94,65 @@ func LoadEnvironmentVariable() {
 		// SMTP configuration with sensible defaults for Gmail
 		SMTPHost: os.Getenv("SMTP_HOST"),
 		SMTPPort: os.Getenv("SMTP_PORT"),
+
+		// Optional: contact mail templates
+		ContactTemplate: os.Getenv("CONTACT_TEMPLATE"),
+		Forms:           loadForms(getEnvString("FORMS_FILE", "forms.json")),
+		TemplateDir:     os.Getenv("TEMPLATE_DIR"),
+		TemplatePoll:    getEnvDuration("TEMPLATE_POLL_INTERVAL", 2*time.Second),
+		AutoReplyLimit:  getEnvInt("AUTO_REPLY_LIMIT", 3),
+		AutoReplyWindow: getEnvDuration("AUTO_REPLY_WINDOW", 24*time.Hour),
+		DefaultLocale:   os.Getenv("DEFAULT_LOCALE"),
+		Timezone:        getEnvLocation("TIMEZONE", time.Local),
+
+		// Optional: batch delivery tuning
+		DedupeProviderRules:  getEnvBool("BATCH_DEDUPE_PROVIDER_RULES", false),
+		BatchWorkersPerBatch: getEnvInt("BATCH_WORKERS_PER_BATCH", 25),
+		DomainDefaultLimit: DomainLimit{
+			RatePerMinute:  getEnvLimit("DOMAIN_RATE_PER_MINUTE", 0),
+			MaxConcurrency: getEnvInt("DOMAIN_MAX_CONCURRENCY", 4),
+		},
+		DomainLimits: parseDomainLimits(os.Getenv("DOMAIN_LIMITS")),
+
+		// Optional: batch size limits
+		BatchMaxEntries:      getEnvLimit("BATCH_MAX_ENTRIES", 10000),
+		BatchMaxBodyBytes:    getEnvLimit("BATCH_MAX_BODY_BYTES", 32<<20),
+		BatchMaxMessageBytes: getEnvLimit("BATCH_MAX_MESSAGE_BYTES", 256<<10),
+		BatchMaxInFlight:     getEnvLimit("BATCH_MAX_IN_FLIGHT", 8),
+
+		// Optional: SMTP connection sharing
+		SMTPMaxConnections: getEnvInt("SMTP_MAX_CONNECTIONS", 4),
+		SMTPMaxQueue:       getEnvInt("SMTP_MAX_QUEUE", 50),
+		SMTPQueueTimeout:   getEnvDuration("SMTP_QUEUE_TIMEOUT", 10*time.Second),
+
+		// Optional: fail fast while the SMTP server is down
+		SMTPBreakerThreshold: getEnvInt("SMTP_BREAKER_THRESHOLD", 5),
+		SMTPBreakerCooldown:  getEnvDuration("SMTP_BREAKER_COOLDOWN", 30*time.Second),
+
+		// Optional: priority lanes (contact mail always goes first)
+		SMTPBulkConnections:   getEnvLimit("SMTP_BULK_CONNECTIONS", 0),
+		SMTPNormalConnections: getEnvLimit("SMTP_NORMAL_CONNECTIONS", 0),
+
+		// Optional: outbound send quota (Gmail allows about 20/sec and 2000/day)
+		SendRatePerSecond: getEnvLimit("SEND_RATE_PER_SECOND", 20),
+		SendRatePerMinute: getEnvLimit("SEND_RATE_PER_MINUTE", 0),
+		SendRatePerDay:    getEnvLimit("SEND_RATE_PER_DAY", 2000),
+		SendQuotaFile:     getEnvString("SEND_QUOTA_FILE", "send_quota.json"),
+
+		// Optional: batch completion webhooks (callback_url needs a secret)
+		WebhookSecret:      os.Getenv("WEBHOOK_SECRET"),
+		WebhookMaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		WebhookTimeout:     getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+
+		// Optional: batch reports
+		ReportDir:       getEnvString("REPORT_DIR", "reports"),
+		ReportRetention: getEnvDuration("REPORT_RETENTION", 30*24*time.Hour),
+
+		// Optional: request handling
+		IdempotencyTTL:      getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		IdempotencyMaxBytes: getEnvInt("IDEMPOTENCY_MAX_BYTES", 64<<20),
+		ShutdownTimeout:     getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		WriteTimeout:        getEnvDuration("WRITE_TIMEOUT", 30*time.Second),
 	}
 
 	if !EnvVar.IsValid() {
@@ -41,6 +162,43 @@ func LoadEnvironmentVariable() {
 	log.Println("✅ Environment configuration loaded successfully.")
 }
 
+// DomainLimit throttles delivery to one recipient domain. Zero means unlimited.
+type DomainLimit struct {
+	RatePerMinute  int // Sends started per minute
+	MaxConcurrency int // Sends in progress at once
+}
+
+// LimitForDomain returns the delivery limit that applies to domain.
+func (env *EnvironmentVariable) LimitForDomain(domain string) DomainLimit {
+	if limit, ok := env.DomainLimits[domain]; ok {
+		return limit
+	}
+	return env.DomainDefaultLimit
+}
+
+// parseDomainLimits reads overrides written as "domain=rate/concurrency",
+// separated by commas, e.g. "outlook.com=60/2,gmail.com=300/4". Either number
+// may be 0 for unlimited. Malformed entries are logged and skipped.
+func parseDomainLimits(raw string) map[string]DomainLimit {
+	limits := make(map[string]DomainLimit)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		domain, values, ok := strings.Cut(entry, "=")
+		rate, concurrency, ok2 := strings.Cut(values, "/")
+		ratePerMinute, err1 := strconv.Atoi(strings.TrimSpace(rate))
+		maxConcurrency, err2 := strconv.Atoi(strings.TrimSpace(concurrency))
+		if !ok || !ok2 || err1 != nil || err2 != nil || ratePerMinute < 0 || maxConcurrency < 0 {
+			log.Printf("⚠️ Ignoring invalid DOMAIN_LIMITS entry %q", entry)
+			continue
+		}
+		limits[strings.ToLower(strings.TrimSpace(domain))] = DomainLimit{RatePerMinute: ratePerMinute, MaxConcurrency: maxConcurrency}
+	}
+	return limits
+}
+
 // IsValid checks if the configuration has all required fields
 // This is useful for validating configuration during startup
 func (env *EnvironmentVariable) IsValid() bool {
@@ -94,3 +252,87 @@ func (env *EnvironmentVariable) IsValid() bool {
 
 	return true
 }
+
+// getEnvBool reads an optional boolean setting, falling back to def when it is
+// unset or cannot be parsed.
+func getEnvBool(key string, def bool) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("⚠️ Ignoring invalid %s=%q, using %v", key, raw, def)
+		return def
+	}
+	return value
+}
+
+// getEnvInt reads an optional positive integer setting, falling back to def
+// when it is unset, invalid or not positive.
+func getEnvInt(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		log.Printf("⚠️ Ignoring invalid %s=%q, using %d", key, raw, def)
+		return def
+	}
+	return value
+}
+
+// getEnvLimit reads an optional rate limit, where 0 means unlimited, falling
+// back to def when it is unset, invalid or negative.
+func getEnvLimit(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		log.Printf("⚠️ Ignoring invalid %s=%q, using %d", key, raw, def)
+		return def
+	}
+	return value
+}
+
+// getEnvString reads an optional setting, falling back to def only when it
+// is not set at all, so an explicitly empty value can switch a feature off.
+func getEnvString(key, def string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return def
+}
+
+// getEnvLocation reads an optional IANA timezone such as "Asia/Kolkata",
+// falling back to def when it is unset or unknown.
+func getEnvLocation(key string, def *time.Location) *time.Location {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := time.LoadLocation(raw)
+	if err != nil {
+		log.Printf("⚠️ Ignoring invalid %s=%q, using %v", key, raw, def)
+		return def
+	}
+	return value
+}
+
+// getEnvDuration reads an optional duration setting such as "90s" or "24h",
+// falling back to def when it is unset, invalid or not positive.
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value <= 0 {
+		log.Printf("⚠️ Ignoring invalid %s=%q, using %v", key, raw, def)
+		return def
+	}
+	return value
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// FormSettings customises contact mail for one form, identified by the
+// product_name its submissions carry.
+type FormSettings struct {
+	Template  string             `json:"template,omitempty"`   // Template used when the payload names none
+	AutoReply *AutoReplySettings `json:"auto_reply,omitempty"` // Set to acknowledge submissions to their sender
+	Locale    string             `json:"locale,omitempty"`     // Used when the submission asks for no locale that is available
+	Timezone  string             `json:"timezone,omitempty"`   // IANA name such as "Asia/Kolkata"; defaults to TIMEZONE
+
+	location *time.Location // Timezone, loaded along with the file
+}
+
+// AutoReplySettings configures the confirmation a form sends to whoever
+// submitted it, with a copy of their message.
+type AutoReplySettings struct {
+	Template string `json:"template,omitempty"` // Defaults to the built-in "auto-reply"
+	ReplyTo  string `json:"reply_to,omitempty"` // Where replies to the confirmation go; defaults to RECEIVER_EMAIL
+}
+
+// Form returns the settings of the form sending as productName, or zero
+// settings when the form is not configured.
+func (env *EnvironmentVariable) Form(productName string) FormSettings {
+	return env.Forms[productName]
+}
+
+// Location returns the timezone times in mail from the form sending as
+// productName are shown in: the form's own, else TIMEZONE, else the server's.
+func (env *EnvironmentVariable) Location(productName string) *time.Location {
+	if location := env.Forms[productName].location; location != nil {
+		return location
+	}
+	if env.Timezone != nil {
+		return env.Timezone
+	}
+	return time.Local
+}
+
+// loadForms reads per-form settings from a JSON object keyed by product name,
+// e.g. {"MySite": {"template": "banner", "auto_reply": {}}}. A missing file means no per-form
+// settings; an unreadable one is logged and ignored.
+func loadForms(path string) map[string]FormSettings {
+	forms := make(map[string]FormSettings)
+	if path == "" {
+		return forms
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return forms
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &forms)
+	}
+	if err != nil {
+		log.Printf("⚠️ Ignoring unreadable forms file %s: %v", path, err)
+		return make(map[string]FormSettings)
+	}
+	for product, form := range forms {
+		if form.Timezone == "" {
+			continue
+		}
+		if form.location, err = time.LoadLocation(form.Timezone); err != nil {
+			log.Printf("⚠️ Form %q: ignoring invalid timezone %q: %v", product, form.Timezone, err)
+		}
+		forms[product] = form
+	}
+	return forms
+}
@@ -1,12 +1,20 @@
 package handler
 
 import (
+	"Form-Mailly-Go/internal/config"
 	"Form-Mailly-Go/internal/model"
 	"Form-Mailly-Go/internal/service"
+	"Form-Mailly-Go/internal/template"
 	"Form-Mailly-Go/internal/validation"
+	"Form-Mailly-Go/internal/webhook"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"runtime"
 	"sync"
@@ -14,9 +22,21 @@ import (
 )
 
 const (
-	numWorkers = 2
+	// batchIDHeader carries the batch ID on the streaming response.
+	batchIDHeader = "X-Batch-ID"
+
+	// queueSize bounds how many entries are buffered between the reader and the
+	// workers (and between the workers and the result stream), so memory stays
+	// flat no matter how many recipients a batch has.
+	queueSize = 64
 )
 
+// batchJob is one entry handed to a worker, along with its position in the batch.
+type batchJob struct {
+	index int
+	email model.Email
+}
+
 var bufPool = sync.Pool{
 	New: func() interface{} {
 		return new(bytes.Buffer)
@@ -28,32 +48,157 @@ func BatchEmailProcessor(response http.ResponseWriter, request *http.Request) {
 
 	totalStart := time.Now()
 
-	// Json to object Processing
-	var emailList []model.Email
-	if err := json.NewDecoder(request.Body).Decode(&emailList); err != nil {
-		http.Error(response, `{"error": "Invalid JSON format"}`, http.StatusBadRequest)
+	mode, err := batchValidationMode(request)
+	if err != nil {
+		writeErrorJSON(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	priority, err := batchPriority(request)
+	if err != nil {
+		writeErrorJSON(response, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// ---------------------
-	// Validate the Email Data
-	for _, email := range emailList {
-
-		errMsg := validateBatchEmailData(email)
-		if errMsg != "" {
-			response.Header().Set("Content-Type", "application/json")
-			response.WriteHeader(http.StatusBadRequest)
-			err := json.NewEncoder(response).Encode(struct {
-				Error string `json:"error"`
-			}{Error: errMsg})
-			if err != nil {
+	// Turn extra batches away before reading their bodies. The slot is given
+	// back when the batch is over, which for a callback_url batch is after
+	// this handler has returned.
+	if !acquireBatch() {
+		writeLimitError(response, http.StatusTooManyRequests, limitError{
+			Error:      fmt.Sprintf("%d batches are already running, try again later", config.EnvVar.BatchMaxInFlight),
+			Code:       codeTooManyBatches,
+			Limit:      config.EnvVar.BatchMaxInFlight,
+			RetryAfter: retryAfterSeconds(batchRetryAfter),
+		})
+		return
+	}
+	detached := false
+	defer func() {
+		if !detached {
+			releaseBatch()
+		}
+	}()
+	limitBatchBody(response, request)
+
+	var source emailSource
+	var merge *template.MailMerge // Set when the batch shares one subject/message template
+	batchSize := queueSize        // Streamed batches have unknown size; the pool adapts as it goes
+	callbackURL := request.URL.Query().Get("callback_url")
+	streaming := isStreamingContentType(request)
+	if streaming {
+		// NDJSON and CSV bodies are decoded while the batch is being sent, so the
+		// request body must stay readable after the response has started. Entries
+		// cannot be checked upfront, so invalid ones are always skipped. The
+		// body arrives as fast as the client sends it, so the server's read
+		// timeout does not apply to it either.
+		controller := http.NewResponseController(response)
+		_ = controller.EnableFullDuplex()
+		_ = controller.SetReadDeadline(time.Time{})
+
+		streamingSource, err := newStreamingSource(request)
+		if err != nil {
+			writeDecodeError(response, err)
+			return
+		}
+		source = streamingSource
+	} else {
+		batch, err := decodeJSONBatch(request.Body)
+		if err != nil {
+			writeDecodeError(response, err)
+			return
+		}
+		if body, ok := tooManyEntries(len(batch.emails)); ok {
+			writeLimitError(response, http.StatusRequestEntityTooLarge, body)
+			return
+		}
+		if body, ok := oversizedMessage(batch.emails); ok {
+			writeLimitError(response, http.StatusRequestEntityTooLarge, body)
+			return
+		}
+
+		// ---------------------
+		// Validate the Email Data
+		// In skip mode the invalid entries are reported by the feeder instead.
+		if errs := validateBatch(batch.emails, batch.merge, mode); len(errs) > 0 {
+			switch mode {
+			case validationStrict:
+				writeValidationErrors(response, errs[0].Message, errs)
+				return
+			case validationReport:
+				writeValidationErrors(response, fmt.Sprintf("%d of %d entries are invalid", len(errs), len(batch.emails)), errs)
 				return
 			}
+		}
+		source, merge = &sliceSource{emails: batch.emails}, batch.merge
+		batchSize = len(batch.emails)
+		if batch.callbackURL != "" {
+			callbackURL = batch.callbackURL
+		}
+	}
+
+	if callbackURL != "" {
+		if config.EnvVar.WebhookSecret == "" {
+			writeErrorJSON(response, http.StatusBadRequest, "callback_url needs WEBHOOK_SECRET to be configured on the server")
 			return
 		}
+		if err := webhook.ValidateURL(request.Context(), callbackURL); err != nil {
+			writeErrorJSON(response, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// Reserve the batch's first SMTP connection before accepting it, so a busy
+	// server or a spent send quota can still answer with a plain 429 instead
+	// of a stalled stream
+	if err := service.CheckSMTPAvailable(); err != nil {
+		writeSaturated(response, err)
+		return
+	}
+	if err := service.CheckSendQuota(priority); err != nil {
+		writeSaturated(response, err)
+		return
+	}
+	batchID := newBatchID()
+	slot, err := service.AcquireSMTPSlot(request.Context(), batchID, priority)
+	if err != nil {
+		writeSaturated(response, err)
+		return
+	}
+	run := &batchRun{
+		id:        batchID,
+		priority:  priority,
+		source:    source,
+		merge:     merge,
+		size:      batchSize,
+		slot:      slot,
+		start:     totalStart,
+		languages: template.ParseAcceptLanguage(request.Header.Get("Accept-Language")),
+	}
+
+	// A JSON batch with a callback_url runs in the background: the client gets
+	// 202 right away and the summary arrives through the webhook. Streamed
+	// bodies are read while sending, so they keep the request (and stream) open.
+	if callbackURL != "" && !streaming {
+		response.Header().Set(batchIDHeader, batchID)
+		response.Header().Set("Content-Type", "application/json")
+		response.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(response).Encode(map[string]string{"batch_id": batchID, "status": "accepted"})
+
+		detached = true
+		runDetached(func(ctx context.Context) {
+			defer releaseBatch()
+			callback := &model.BatchCallback{Event: "batch.finished"}
+			summary, _ := run.execute(ctx, func(result *model.EmailResult) bool {
+				addFailure(callback, result)
+				return true
+			})
+			callback.BatchSummary = summary
+			deliverCallback(ctx, callbackURL, callback)
+		})
+		return
 	}
 
 	// Setting headers
+	response.Header().Set(batchIDHeader, batchID)
 	response.Header().Set("Content-Type", "text/event-stream")
 	response.Header().Set("Cache-Control", "no-cache")
 	response.Header().Set("Connection", "keep-alive")
@@ -61,125 +206,284 @@ func BatchEmailProcessor(response http.ResponseWriter, request *http.Request) {
 
 	flusher, ok := response.(http.Flusher)
 	if !ok {
+		slot.Release()
 		http.Error(response, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
+	// The stream lasts as long as the batch, so WRITE_TIMEOUT does not apply to it
+	_ = http.NewResponseController(response).SetWriteDeadline(time.Time{})
 
-	emailChan := make(chan model.Email, len(emailList))
-	resultChan := make(chan *model.EmailResult, len(emailList))
+	ResultSendingTime := time.Now()
+	callback := &model.BatchCallback{Event: "batch.finished"}
 
-	// Detect client disconnect
-	notify := request.Context().Done()
+	// Stream updates
+	summary, abandoned := run.execute(request.Context(), func(result *model.EmailResult) bool {
+		if callbackURL != "" {
+			addFailure(callback, result)
+		}
+		if err := writeEvent(response, "", result); err != nil {
+			return false // If the write fails (e.g. client gone), stop streaming
+		}
+		flusher.Flush()
+		return true
+	})
 
-	var wg sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
+	// Close the stream with a summary, telling the client whether every entry was processed
+	if !abandoned {
+		if err := writeEvent(response, "summary", summary); err == nil {
+			flusher.Flush()
+		}
+	}
+	fmt.Println("Result Sending time taken is", time.Since(ResultSendingTime))
 
-			EachSMTPWorkerStart := time.Now()
-			conn, err := service.SetupNewSMTPConnection()
-			if err != nil {
-				fmt.Printf("Worker %d: failed to setup SMTP connection: %v\n", workerID, err)
-				return // Bail out this worker to avoid spinning with a nil client
-			}
-			fmt.Printf("Worker %d setup time: %v\n", workerID, time.Since(EachSMTPWorkerStart))
+	if callbackURL != "" {
+		callback.BatchSummary = summary
+		runDetached(func(ctx context.Context) { deliverCallback(ctx, callbackURL, callback) })
+	}
+
+	totalDuration := time.Since(totalStart)
+	fmt.Println("Total time taken:", totalDuration)
+}
 
-			defer service.CloseSMTPConnection(conn)
+// batchPriority reads the ?priority= parameter. Batches are bulk unless they
+// ask for normal; transactional is kept for contact form mail.
+func batchPriority(request *http.Request) (service.Priority, error) {
+	switch name := request.URL.Query().Get("priority"); name {
+	case "":
+		return service.PriorityBulk, nil
+	case service.PriorityBulk.String(), service.PriorityNormal.String():
+		return service.ParsePriority(name)
+	default:
+		return 0, fmt.Errorf("priority must be %s or %s", service.PriorityBulk, service.PriorityNormal)
+	}
+}
 
-			for {
-				select {
+// batchRun is one accepted batch, ready to be sent.
+type batchRun struct {
+	id        string
+	priority  service.Priority
+	source    emailSource
+	merge     *template.MailMerge
+	size      int               // Entry count if known, to size the worker pool
+	slot      *service.SMTPSlot // First SMTP slot, acquired before the batch was accepted
+	start     time.Time
+	languages []string // Accept-Language tags of the request, for entries without a locale
+}
 
-				case <-notify:
-					fmt.Println("Client disconnected - Email processing stopped")
-					return
+// execute sends every entry and hands each result to report, one at a time on
+// the calling goroutine. When ctx ends no new email is started; a server
+// shutdown still reports what finished, while any other cancellation, or
+// report returning false, abandons the remaining results. abandoned tells
+// whether that happened.
+func (run *batchRun) execute(ctx context.Context, report func(*model.EmailResult) bool) (summary model.BatchSummary, abandoned bool) {
+	// Entries wait here per recipient domain, so domain limits never stall other domains
+	dispatcher := newDomainDispatcher(dispatchWindow)
+	resultChan := make(chan *model.EmailResult, queueSize)
+
+	// Results are only abandoned when nobody can read them any more. During a
+	// shutdown the stream stays open, so finished sends are still reported.
+	streamGone := make(chan struct{})
+	var streamGoneOnce sync.Once
+	abandonStream := func() { streamGoneOnce.Do(func() { close(streamGone) }) }
+	stopWatching := context.AfterFunc(ctx, func() {
+		if !interrupted(ctx) {
+			abandonStream()
+		}
+	})
+	defer stopWatching()
+
+	// sendResult hands a result to the stream, giving up if the client is gone
+	sendResult := func(result *model.EmailResult) bool {
+		select {
+		case resultChan <- result:
+			return true
+		case <-streamGone:
+			return false
+		}
+	}
 
-				case email, ok := <-emailChan:
-					EmailSentEach := time.Now()
+	var wg sync.WaitGroup
+	pool := &batchPool{
+		batchID:    run.id,
+		priority:   run.priority,
+		ctx:        ctx,
+		jobs:       dispatcher,
+		merge:      run.merge,
+		languages:  run.languages,
+		sendResult: sendResult,
+		maxWorkers: int32(config.EnvVar.BatchWorkersPerBatch),
+		wg:         &wg,
+	}
+	pool.start(getNumberOfWorkers(run.size), run.slot)
 
-					if !ok {
-						return // channel closed, no more jobs
-					}
+	// feeder goroutine: reads entries as they arrive and hands them to the workers
+	wg.Go(func() {
+		defer dispatcher.Close()
 
-					err := service.SendEmailUsingWorker(conn, &email)
+		// First index seen for each recipient, so pasted lists never email anyone twice
+		seen := make(map[string]int)
 
-					res := &model.EmailResult{Email: email.SentTo}
-					if err != nil {
-						res.Status = "failed"
-						res.Error = err.Error()
-					} else {
-						res.Status = "success"
-					}
-					resultChan <- res
-					fmt.Println("Each time taken is", time.Since(EmailSentEach))
+		for index := 0; ; index++ {
+			email, err := run.source.Next()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				// The rest of the body cannot be trusted, so stop reading here
+				if body, tooLarge := bodyTooLarge(err); tooLarge {
+					err = errors.New(body.Error)
 				}
+				sendResult(&model.EmailResult{Index: index, Status: "failed", Error: err.Error()})
+				return
+			}
+			if body, ok := tooManyEntries(index + 1); ok {
+				// Streamed bodies are only counted while sending, so the rest is refused here
+				sendResult(&model.EmailResult{Index: index, Status: "failed", Error: body.Error})
+				return
 			}
 
-		}(i)
-	}
+			// Invalid entries only reach this point in skip mode or when streamed
+			verr := checkMessageSize(index, email)
+			if verr == nil {
+				verr = validateBatchEntry(index, email, run.merge)
+			}
+			if verr != nil {
+				if !sendResult(&model.EmailResult{Index: index, Email: email.SentTo, Status: "skipped", Field: verr.Field, Error: verr.Message}) {
+					return
+				}
+				continue
+			}
 
-	// feeder goroutine
-	wg.Go(func() {
-		for _, email := range emailList {
-			emailChan <- email
-		}
-		close(emailChan)
-	})
+			email.SentTo = validation.NormalizeEmail(email.SentTo)
+			key := validation.DedupeKey(email.SentTo, config.EnvVar.DedupeProviderRules)
+			if first, duplicate := seen[key]; duplicate {
+				if !sendResult(&model.EmailResult{Index: index, Email: email.SentTo, Status: "skipped", Error: fmt.Sprintf("duplicate of entry %d", first)}) {
+					return
+				}
+				continue
+			}
+			seen[key] = index
 
-	// result sender: drains resultChan and streams to client
-	var resultWG sync.WaitGroup
-	resultWG.Add(1)
-	go (func() {
-		defer resultWG.Done()
-		ResultSendingTime := time.Now()
-
-		// Detect client disconnect
-		notify := request.Context().Done()
-
-		// Stream updates
-		for result := range resultChan {
-			select {
-			case <-notify:
-				fmt.Println("Client disconnected - Result sending stopped")
+			// Client disconnect or server shutdown: either way no new email is queued
+			if !dispatcher.Put(ctx, batchJob{index: index, email: email}) {
 				return
-			default:
-				// Get and reset buffer
-				buf := bufPool.Get().(*bytes.Buffer)
-				buf.Reset() // Clears old data before reuse — avoids data corruption or leaks.
-
-				// Encode JSON into buffer
-				err := json.NewEncoder(buf).Encode(result)
-				if err == nil {
-					// Stream it using SSE format: "data: <json>\n\n"
-					_, err = fmt.Fprintf(response, "data: %s\n\n", buf.Bytes())
-					if err != nil {
-						// If the write fails (e.g. client gone), exit
-						bufPool.Put(buf)
-						return
-					}
-
-					flusher.Flush()
-				}
-				// Return buffer to pool
-				bufPool.Put(buf)
 			}
 		}
-		fmt.Println("Result Sending time taken is", time.Since(ResultSendingTime))
-	})()
+	})
 
-	// Once all producers are done, close resultChan so result-sender can finish
+	// Once all producers are done, close resultChan so the loop below can finish
 	go func() {
 		wg.Wait()
 		close(resultChan)
 	}()
 
-	// wait for result-sender to finish (which happens once resultChan is closed/drained)
-	resultWG.Wait()
-	totalDuration := time.Since(totalStart)
-	fmt.Println("Total time taken:", totalDuration)
+	// Every result is kept on disk for GET /api/batch/{id}/report
+	recorder := startReport(run.id)
+
+	summary = model.BatchSummary{BatchID: run.id, Status: "completed"}
+	defer func() {
+		switch {
+		case interrupted(ctx):
+			summary.Status = "interrupted"
+		case abandoned || ctx.Err() != nil:
+			summary.Status = "cancelled"
+		}
+		summary.DurationMs = time.Since(run.start).Milliseconds()
+		recorder.Finish(summary)
+	}()
+
+	for result := range resultChan {
+		select {
+		case <-streamGone:
+			fmt.Println("Client disconnected - Result sending stopped")
+			return summary, true
+		default:
+		}
+		summary.Add(result)
+		recorder.Add(result)
+		if !report(result) {
+			abandonStream()
+			return summary, true
+		}
+	}
+	return summary, false
+}
+
+// newBatchID returns a random identifier for a batch.
+func newBatchID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeSaturated answers 429 with a Retry-After header when every SMTP
+// connection is busy or the send quota is spent, and 503 with one while the
+// SMTP circuit breaker is open. Other acquisition errors are passed on as 503.
+func writeSaturated(response http.ResponseWriter, err error) {
+	body := limitError{Error: err.Error(), Code: codeSMTPSaturated, RetryAfter: retryAfterSeconds(service.SMTPRetryAfter())}
+	var quotaErr *service.QuotaError
+	var circuitErr *service.CircuitOpenError
+	if errors.As(err, &circuitErr) {
+		body.Code, body.RetryAfter = codeSMTPUnavailable, retryAfterSeconds(circuitErr.RetryAfter)
+		writeLimitError(response, http.StatusServiceUnavailable, body)
+		return
+	}
+	if errors.As(err, &quotaErr) {
+		body.Code, body.RetryAfter = codeSendQuota, retryAfterSeconds(quotaErr.RetryAfter)
+	} else if !errors.Is(err, service.ErrSMTPSaturated) {
+		writeErrorJSON(response, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeLimitError(response, http.StatusTooManyRequests, body)
+}
+
+// writeDecodeError rejects an unreadable batch body: 413 when it is over the
+// size limit and 400 otherwise.
+func writeDecodeError(response http.ResponseWriter, err error) {
+	if body, tooLarge := bodyTooLarge(err); tooLarge {
+		writeLimitError(response, http.StatusRequestEntityTooLarge, body)
+		return
+	}
+	writeErrorJSON(response, http.StatusBadRequest, err.Error())
+}
+
+// writeEvent streams v as one SSE event: an optional "event: <name>" line
+// followed by "data: <json>".
+func writeEvent(response http.ResponseWriter, name string, v any) error {
+	// Get and reset buffer
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset() // Clears old data before reuse — avoids data corruption or leaks.
+	defer bufPool.Put(buf)
+
+	if name != "" {
+		buf.WriteString("event: " + name + "\n")
+	}
+	buf.WriteString("data: ")
+	// Encode JSON into buffer (the encoder adds the line break)
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+
+	_, err := response.Write(buf.Bytes())
+	return err
+}
+
+// writeErrorJSON writes a {"error": "..."} body with the given status code.
+func writeErrorJSON(response http.ResponseWriter, status int, errMsg string) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	err := json.NewEncoder(response).Encode(struct {
+		Error string `json:"error"`
+	}{Error: errMsg})
+	if err != nil {
+		return
+	}
 }
 
-func validateBatchEmailData(email model.Email) string {
+// validateBatchEmailData returns the first validation error of an entry and the
+// field it concerns, or empty strings when the entry is valid.
+func validateBatchEmailData(email model.Email) (string, string) {
 
 	validator := validation.NewValidator()
 	fields := []validation.Field{
@@ -220,11 +524,11 @@ func validateBatchEmailData(email model.Email) string {
 		validator.ValidateField(field)
 		if !validator.IsValid() {
 			// Return immediately once an error occurs
-			return validator.Error
+			return validator.Error, validator.Field
 		}
 	}
 
-	return "" // no error found, valid form
+	return "", "" // no error found, valid form
 }
 
 // getNumberOfWorkers calculates the optimal number of SMTP workers for email batch processing.
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/model"
+	"Form-Mailly-Go/internal/webhook"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// maxCallbackFailures bounds the failed entries listed in one callback, so
+// a batch that fails wholesale still produces a reasonably sized payload.
+const maxCallbackFailures = 1000
+
+// callbackSender is created from the configuration on first use.
+var callbackSender = sync.OnceValue(func() *webhook.Sender {
+	return webhook.NewSender(config.EnvVar.WebhookSecret, config.EnvVar.WebhookMaxAttempts, config.EnvVar.WebhookTimeout)
+})
+
+// addFailure records result in the callback if it failed.
+func addFailure(callback *model.BatchCallback, result *model.EmailResult) {
+	if result.Status != "failed" {
+		return
+	}
+	if len(callback.Failures) >= maxCallbackFailures {
+		callback.FailuresTruncated = true
+		return
+	}
+	callback.Failures = append(callback.Failures, *result)
+}
+
+// deliverCallback POSTs the finished batch's summary to its callback_url.
+func deliverCallback(ctx context.Context, callbackURL string, callback *model.BatchCallback) {
+	if callback.Failures == nil {
+		callback.Failures = []model.EmailResult{} // Receivers always get a list
+	}
+	if err := callbackSender().Deliver(ctx, callbackURL, callback); err != nil {
+		fmt.Printf("Batch %s: %v\n", callback.BatchID, err)
+		return
+	}
+	fmt.Printf("Batch %s: summary delivered to callback_url\n", callback.BatchID)
+}
@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dispatchWindow bounds how many entries a batch buffers across all domains.
+// A larger window than the worker queue lets domains interleave even when the
+// input is sorted by domain, while still keeping memory flat.
+const dispatchWindow = 512
+
+// domainDispatcher sits between the feeder and the workers of one batch. It
+// queues entries per recipient domain and hands them out round-robin, only
+// from domains under their concurrency limit and rate, so one slow receiving
+// server never stalls the whole batch.
+type domainDispatcher struct {
+	mu       sync.Mutex
+	domains  map[string]*domainQueue
+	ring     []string // Domains with queued entries, in round-robin order
+	next     int      // Ring position to try first
+	pending  int      // Entries queued across all domains
+	capacity int
+	closed   bool
+	wake     chan struct{} // Closed and replaced whenever the state changes
+	drained  chan struct{} // Closed once the input has ended and every entry is handed out
+}
+
+// domainQueue holds the waiting entries and delivery state of one domain.
+type domainQueue struct {
+	jobs     []batchJob
+	inFlight int
+	limit    config.DomainLimit
+	nextAt   time.Time // Earliest start of the next send under the rate limit
+}
+
+func newDomainDispatcher(capacity int) *domainDispatcher {
+	return &domainDispatcher{
+		domains:  make(map[string]*domainQueue),
+		capacity: capacity,
+		wake:     make(chan struct{}),
+		drained:  make(chan struct{}),
+	}
+}
+
+// recipientDomain returns the lowercased domain of an address.
+func recipientDomain(address string) string {
+	return strings.ToLower(address[strings.LastIndexByte(address, '@')+1:])
+}
+
+// Put queues a job, waiting while the window is full. It returns false if ctx ends first.
+func (d *domainDispatcher) Put(ctx context.Context, job batchJob) bool {
+	domain := recipientDomain(job.email.SentTo)
+
+	d.mu.Lock()
+	for d.pending >= d.capacity {
+		wake := d.wake
+		d.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return false
+		}
+		d.mu.Lock()
+	}
+	defer d.mu.Unlock()
+
+	q, ok := d.domains[domain]
+	if !ok {
+		q = &domainQueue{limit: config.EnvVar.LimitForDomain(domain)}
+		d.domains[domain] = q
+	}
+	if len(q.jobs) == 0 {
+		d.ring = append(d.ring, domain)
+	}
+	q.jobs = append(q.jobs, job)
+	d.pending++
+	d.broadcastLocked()
+	return true
+}
+
+// Close marks the end of the input; Take reports false once everything is handed out.
+func (d *domainDispatcher) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	d.broadcastLocked()
+}
+
+// Take waits for the next job a worker may send. With limited unset the
+// domain limits are ignored, for reporting entries that will not be sent.
+// done must be called once the send has finished. ok is false when the input
+// is exhausted or ctx ends.
+func (d *domainDispatcher) Take(ctx context.Context, limited bool) (job batchJob, done func(), ok bool) {
+	for {
+		d.mu.Lock()
+		if d.pending == 0 && d.closed {
+			d.mu.Unlock()
+			return batchJob{}, nil, false
+		}
+
+		now := time.Now()
+		var retryAt time.Time // Earliest moment a rate-limited domain opens up
+		for i := range d.ring {
+			position := (d.next + i) % len(d.ring)
+			domain := d.ring[position]
+			q := d.domains[domain]
+
+			if limited {
+				if q.limit.MaxConcurrency > 0 && q.inFlight >= q.limit.MaxConcurrency {
+					continue
+				}
+				if now.Before(q.nextAt) {
+					if retryAt.IsZero() || q.nextAt.Before(retryAt) {
+						retryAt = q.nextAt
+					}
+					continue
+				}
+			}
+
+			job = q.jobs[0]
+			q.jobs[0] = batchJob{}
+			q.jobs = q.jobs[1:]
+			q.inFlight++
+			if q.limit.RatePerMinute > 0 {
+				q.nextAt = now.Add(time.Minute / time.Duration(q.limit.RatePerMinute))
+			}
+			d.pending--
+
+			if len(q.jobs) == 0 {
+				d.ring = append(d.ring[:position], d.ring[position+1:]...)
+				d.next = position
+			} else {
+				d.next = position + 1
+			}
+			if len(d.ring) > 0 {
+				d.next %= len(d.ring)
+			} else {
+				d.next = 0
+			}
+
+			d.broadcastLocked()
+			d.mu.Unlock()
+			return job, func() { d.finish(domain) }, true
+		}
+
+		wake := d.wake
+		d.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !retryAt.IsZero() {
+			timer = time.NewTimer(time.Until(retryAt))
+			timeout = timer.C
+		}
+		select {
+		case <-wake:
+		case <-timeout:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return batchJob{}, nil, false
+		}
+	}
+}
+
+// finish records the end of a send to domain.
+func (d *domainDispatcher) finish(domain string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	q := d.domains[domain]
+	q.inFlight--
+	if q.inFlight == 0 && len(q.jobs) == 0 && !time.Now().Before(q.nextAt) {
+		delete(d.domains, domain) // Forget domains that are done, keeping memory flat
+	}
+	d.broadcastLocked()
+}
+
+// Drained is closed once Close has been called and every entry has been taken.
+func (d *domainDispatcher) Drained() <-chan struct{} {
+	return d.drained
+}
+
+// Ready returns how many entries could be handed out right now, which is the
+// backlog extra workers would actually help with.
+func (d *domainDispatcher) Ready() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	ready := 0
+	for _, domain := range d.ring {
+		q := d.domains[domain]
+		if now.Before(q.nextAt) {
+			continue
+		}
+		n := len(q.jobs)
+		if q.limit.MaxConcurrency > 0 {
+			n = min(n, max(0, q.limit.MaxConcurrency-q.inFlight))
+		}
+		if q.limit.RatePerMinute > 0 {
+			n = min(n, 1) // The next send of a rate-limited domain has to wait its turn
+		}
+		ready += n
+	}
+	return ready
+}
+
+// broadcastLocked wakes every goroutine waiting on the dispatcher. Callers must hold d.mu.
+func (d *domainDispatcher) broadcastLocked() {
+	close(d.wake)
+	d.wake = make(chan struct{})
+	if d.closed && d.pending == 0 {
+		select {
+		case <-d.drained:
+		default:
+			close(d.drained)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/model"
+	"context"
+	"testing"
+	"time"
+)
+
+func withConfig(t *testing.T, env *config.EnvironmentVariable) {
+	t.Helper()
+	previous := config.EnvVar
+	config.EnvVar = env
+	t.Cleanup(func() { config.EnvVar = previous })
+}
+
+func TestDomainDispatcherInterleavesDomains(t *testing.T) {
+	withConfig(t, &config.EnvironmentVariable{})
+
+	d := newDomainDispatcher(10)
+	ctx := context.Background()
+	for i, to := range []string{"a@slow.com", "b@slow.com", "c@slow.com", "d@fast.com", "e@fast.com"} {
+		d.Put(ctx, batchJob{index: i, email: model.Email{SentTo: to}})
+	}
+	d.Close()
+
+	var got []string
+	for {
+		job, done, ok := d.Take(ctx, true)
+		if !ok {
+			break
+		}
+		done()
+		got = append(got, job.email.SentTo)
+	}
+	want := []string{"a@slow.com", "d@fast.com", "b@slow.com", "e@fast.com", "c@slow.com"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestDomainDispatcherLimits(t *testing.T) {
+	withConfig(t, &config.EnvironmentVariable{
+		DomainLimits: map[string]config.DomainLimit{
+			"busy.com":    {MaxConcurrency: 1},
+			"metered.com": {RatePerMinute: 1},
+		},
+	})
+
+	d := newDomainDispatcher(10)
+	ctx := context.Background()
+	d.Put(ctx, batchJob{index: 0, email: model.Email{SentTo: "a@busy.com"}})
+	d.Put(ctx, batchJob{index: 1, email: model.Email{SentTo: "b@busy.com"}})
+	d.Put(ctx, batchJob{index: 2, email: model.Email{SentTo: "a@metered.com"}})
+	d.Put(ctx, batchJob{index: 3, email: model.Email{SentTo: "b@metered.com"}})
+
+	busy, doneBusy, _ := d.Take(ctx, true)
+	metered, doneMetered, _ := d.Take(ctx, true)
+	if busy.index != 0 || metered.index != 2 {
+		t.Fatalf("first takes = %d, %d; want 0, 2", busy.index, metered.index)
+	}
+	doneMetered()
+	if ready := d.Ready(); ready != 0 {
+		t.Fatalf("Ready() = %d while both domains are limited, want 0", ready)
+	}
+
+	// Nothing may be handed out until the busy.com send finishes
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, _, ok := d.Take(short, true); ok {
+		t.Fatal("Take handed out an entry over the domain limits")
+	}
+
+	doneBusy()
+	next, done, ok := d.Take(ctx, true)
+	if !ok || next.index != 1 {
+		t.Fatalf("after finishing busy.com got index %d (ok=%v), want 1", next.index, ok)
+	}
+	done()
+
+	// Reporting ignores the limits, so undeliverable entries are never stuck
+	last, done, ok := d.Take(ctx, false)
+	if !ok || last.index != 3 {
+		t.Fatalf("unlimited take got index %d (ok=%v), want 3", last.index, ok)
+	}
+	done()
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Codes of limit errors, so clients can tell which limit a request ran into.
+const (
+	codeBodyTooLarge    = "body_too_large"
+	codeTooManyEntries  = "too_many_entries"
+	codeMessageTooLarge = "message_too_large"
+	codeTooManyBatches  = "too_many_batches"
+	codeSMTPSaturated   = "smtp_saturated"
+	codeSendQuota       = "send_quota_exceeded"
+	codeSMTPUnavailable = "smtp_unavailable"
+)
+
+// batchRetryAfter is suggested to clients turned away because too many batches are running.
+const batchRetryAfter = 30 * time.Second
+
+// limitError is the body of 413 and 429 answers, and of 503 while the SMTP
+// server is down. Code names the limit, Limit its configured value and
+// RetryAfter repeats the Retry-After header in seconds.
+type limitError struct {
+	Error      string `json:"error"`
+	Code       string `json:"code"`
+	Limit      int    `json:"limit,omitempty"`
+	Index      *int   `json:"index,omitempty"` // Entry over the limit, for per-entry limits
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// writeLimitError answers with status and body, setting Retry-After when the body has one.
+func writeLimitError(response http.ResponseWriter, status int, body limitError) {
+	if body.RetryAfter > 0 {
+		response.Header().Set("Retry-After", strconv.Itoa(body.RetryAfter))
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	_ = json.NewEncoder(response).Encode(body)
+}
+
+// retryAfterSeconds rounds a delay up to the whole seconds of a Retry-After header.
+func retryAfterSeconds(delay time.Duration) int {
+	return max(1, int(math.Ceil(delay.Seconds())))
+}
+
+// inFlightBatches counts batches from acceptance until their last result,
+// including those reporting through callback_url.
+var inFlightBatches atomic.Int64
+
+// acquireBatch counts a new batch in, unless BATCH_MAX_IN_FLIGHT are already running.
+func acquireBatch() bool {
+	limit := int64(config.EnvVar.BatchMaxInFlight)
+	for {
+		running := inFlightBatches.Load()
+		if limit > 0 && running >= limit {
+			return false
+		}
+		if inFlightBatches.CompareAndSwap(running, running+1) {
+			return true
+		}
+	}
+}
+
+// releaseBatch counts a batch out once it has finished.
+func releaseBatch() {
+	inFlightBatches.Add(-1)
+}
+
+// limitBatchBody caps the request body at BATCH_MAX_BODY_BYTES. Reading past it
+// fails with *http.MaxBytesError and closes the connection afterwards.
+func limitBatchBody(response http.ResponseWriter, request *http.Request) {
+	if limit := config.EnvVar.BatchMaxBodyBytes; limit > 0 {
+		request.Body = http.MaxBytesReader(response, request.Body, int64(limit))
+	}
+}
+
+// bodyTooLarge returns the error explaining that the body went past the limit, if err says so.
+func bodyTooLarge(err error) (limitError, bool) {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return limitError{}, false
+	}
+	return limitError{
+		Error: fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit),
+		Code:  codeBodyTooLarge,
+		Limit: int(tooLarge.Limit),
+	}, true
+}
+
+// tooManyEntries returns the error for a batch of count entries, if it has more than BATCH_MAX_ENTRIES.
+func tooManyEntries(count int) (limitError, bool) {
+	limit := config.EnvVar.BatchMaxEntries
+	if limit == 0 || count <= limit {
+		return limitError{}, false
+	}
+	return limitError{
+		Error: fmt.Sprintf("batch has more than %d entries", limit),
+		Code:  codeTooManyEntries,
+		Limit: limit,
+	}, true
+}
+
+// checkMessageSize reports an entry whose message is larger than BATCH_MAX_MESSAGE_BYTES.
+func checkMessageSize(index int, email model.Email) *model.ValidationError {
+	limit := config.EnvVar.BatchMaxMessageBytes
+	if limit == 0 || len(email.Message) <= limit {
+		return nil
+	}
+	return &model.ValidationError{Index: index, Field: "message", Message: fmt.Sprintf("message exceeds %d bytes", limit)}
+}
+
+// oversizedMessage returns the error for the first entry whose message is over the limit.
+func oversizedMessage(emails []model.Email) (limitError, bool) {
+	for i, email := range emails {
+		if verr := checkMessageSize(i, email); verr != nil {
+			return limitError{
+				Error: fmt.Sprintf("entry %d: %s", i, verr.Message),
+				Code:  codeMessageTooLarge,
+				Limit: config.EnvVar.BatchMaxMessageBytes,
+				Index: &i,
+			}, true
+		}
+	}
+	return limitError{}, false
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postBatch(body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/api/batch/contact", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	BatchEmailProcessor(response, request)
+	return response
+}
+
+func decodeLimitError(t *testing.T, response *httptest.ResponseRecorder) limitError {
+	t.Helper()
+	var body limitError
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatalf("Invalid error body: %v", err)
+	}
+	return body
+}
+
+func TestBatchLimitsAnswer413(t *testing.T) {
+	withConfig(t, &config.EnvironmentVariable{BatchMaxEntries: 2, BatchMaxBodyBytes: 300, BatchMaxMessageBytes: 10})
+
+	entry := `{"sent_to":"a@example.com","subject":"Hi","message":"Hello"}`
+	tests := []struct {
+		name  string
+		body  string
+		code  string
+		limit int
+	}{
+		{"entries", "[" + strings.Repeat(entry+",", 2) + entry + "]", codeTooManyEntries, 2},
+		{"body", "[" + strings.Repeat(entry+",", 10) + entry + "]", codeBodyTooLarge, 300},
+		{"message", `[` + entry + `,{"sent_to":"b@example.com","subject":"Hi","message":"Hello there, world"}]`, codeMessageTooLarge, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := postBatch(tt.body)
+			if response.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("Expected 413, got %d: %s", response.Code, response.Body)
+			}
+			body := decodeLimitError(t, response)
+			if body.Code != tt.code || body.Limit != tt.limit || body.Error == "" {
+				t.Errorf("Unexpected error body %+v", body)
+			}
+		})
+	}
+	if body := decodeLimitError(t, postBatch(tests[2].body)); body.Index == nil || *body.Index != 1 {
+		t.Errorf("Expected the oversized entry's index, got %+v", body)
+	}
+	if running := inFlightBatches.Load(); running != 0 {
+		t.Errorf("Rejected batches left %d in flight", running)
+	}
+}
+
+func TestBatchInFlightLimitAnswers429(t *testing.T) {
+	withConfig(t, &config.EnvironmentVariable{BatchMaxInFlight: 1})
+	if !acquireBatch() {
+		t.Fatal("Expected the first batch to be let in")
+	}
+	defer releaseBatch()
+
+	response := postBatch(`[]`)
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected 429 with Retry-After, got %d: %s", response.Code, response.Body)
+	}
+	if body := decodeLimitError(t, response); body.Code != codeTooManyBatches || body.Limit != 1 {
+		t.Errorf("Unexpected error body %+v", body)
+	}
+}
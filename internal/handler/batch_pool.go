@@ -0,0 +1,312 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/model"
+	"Form-Mailly-Go/internal/monitoring"
+	"Form-Mailly-Go/internal/service"
+	"Form-Mailly-Go/internal/template"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scaleInterval is how often a batch pool reviews its worker count.
+const scaleInterval = 2 * time.Second
+
+// batchPool runs the SMTP workers of one batch. It starts from
+// getNumberOfWorkers and resizes while the batch runs: it adds a worker while
+// entries are piling up and sends stay fast, and sheds workers when latency
+// climbs or the server answers 421/451 (throttling). Every worker holds one of
+// the process-wide SMTP slots, so concurrent batches share the connection cap.
+type batchPool struct {
+	batchID    string           // Tenant name when sharing SMTP slots with other senders
+	priority   service.Priority // Class of every slot and quota request of the batch
+	ctx        context.Context
+	jobs       *domainDispatcher
+	merge      *template.MailMerge
+	languages  []string // Accept-Language tags of the batch request
+	sendResult func(*model.EmailResult) bool
+	maxWorkers int32
+	wg         *sync.WaitGroup
+
+	nextID  atomic.Int32
+	target  atomic.Int32 // Desired number of workers
+	running atomic.Int32 // Workers currently alive
+
+	// Observations since the last scaling decision
+	sends     atomic.Int64
+	latencyNs atomic.Int64
+	throttled atomic.Int64
+
+	baseline time.Duration // Average send latency of the first window, used by control only
+}
+
+// start launches the initial workers and the goroutine resizing the pool.
+// The first worker uses slot, acquired by the caller before the batch was
+// accepted; the others start only if the shared SMTP slots allow it.
+func (p *batchPool) start(workers int, slot *service.SMTPSlot) {
+	workers = max(1, min(workers, int(p.maxWorkers)))
+	p.target.Store(int32(workers))
+	p.spawn(slot)
+	for range workers - 1 {
+		if !p.trySpawn() {
+			break // The controller adds the rest once slots free up
+		}
+	}
+	p.wg.Go(p.control)
+}
+
+// spawn starts one more worker holding slot.
+func (p *batchPool) spawn(slot *service.SMTPSlot) {
+	p.running.Add(1)
+	id := int(p.nextID.Add(1))
+	p.wg.Go(func() { p.runWorker(id, slot) })
+}
+
+// trySpawn starts one more worker if an SMTP slot is free right now.
+func (p *batchPool) trySpawn() bool {
+	slot, ok := service.TryAcquireSMTPSlot(p.batchID, p.priority)
+	if !ok {
+		return false
+	}
+	p.spawn(slot)
+	return true
+}
+
+// spawnWaiting starts a worker that queues for an SMTP slot. It is used when
+// the pool has no worker left, so the batch waits its turn like any sender.
+func (p *batchPool) spawnWaiting() {
+	p.running.Add(1)
+	p.wg.Go(func() {
+		slot, err := service.AcquireSMTPSlot(p.ctx, p.batchID, p.priority)
+		if err != nil {
+			p.running.Add(-1) // Try again on the next tick
+			return
+		}
+		p.runWorker(int(p.nextID.Add(1)), slot)
+	})
+}
+
+// retire lets a worker leave when the pool is larger than its target.
+func (p *batchPool) retire() bool {
+	for {
+		running := p.running.Load()
+		if running <= p.target.Load() {
+			return false
+		}
+		if p.running.CompareAndSwap(running, running-1) {
+			return true
+		}
+	}
+}
+
+func (p *batchPool) runWorker(workerID int, slot *service.SMTPSlot) {
+	defer slot.Release()
+	monitoring.AddBatchWorkers(1)
+	defer monitoring.AddBatchWorkers(-1)
+
+	EachSMTPWorkerStart := time.Now()
+	conn, err := service.SetupNewSMTPConnection()
+	if err != nil {
+		fmt.Printf("Worker %d: failed to setup SMTP connection: %v\n", workerID, err)
+
+		// Workers that could not reach the SMTP server step aside; the last one to
+		// give up reports the remaining entries so the feeder is never left blocked.
+		if p.running.Add(-1) > 0 {
+			return // Other workers carry on with the batch
+		}
+		for {
+			job, done, ok := p.jobs.Take(p.ctx, false)
+			if !ok {
+				return
+			}
+			done()
+			if !p.sendResult(&model.EmailResult{Index: job.index, Email: job.email.SentTo, Status: "failed", Error: err.Error()}) {
+				return
+			}
+		}
+	}
+	fmt.Printf("Worker %d setup time: %v\n", workerID, time.Since(EachSMTPWorkerStart))
+
+	defer service.CloseSMTPConnection(conn)
+
+	for {
+		// Checked first so a cancelled batch never starts another email
+		if p.ctx.Err() != nil {
+			p.running.Add(-1)
+			fmt.Println("Batch cancelled - Email processing stopped")
+			return
+		}
+		if p.retire() {
+			fmt.Printf("Worker %d retired\n", workerID)
+			return
+		}
+
+		// Waits for an entry whose domain is under its rate and concurrency limits
+		job, done, ok := p.jobs.Take(p.ctx, true)
+		if !ok {
+			p.running.Add(-1)
+			if p.ctx.Err() != nil {
+				fmt.Println("Batch cancelled - Email processing stopped")
+			}
+			return // no more jobs
+		}
+
+		email := job.email
+
+		var err error
+		if p.merge != nil {
+			// Render this recipient's copy of the shared template
+			email.Subject, email.Message, err = p.merge.Render(email.Data)
+		}
+		if err == nil {
+			err = template.RenderEntry(&email, p.locale(&email), time.Now().In(config.EnvVar.Location(email.ProductName)))
+		}
+		if err == nil {
+			// Waiting for quota is not counted as send latency
+			err = service.ReserveSend(p.ctx, p.priority)
+			if p.ctx.Err() != nil {
+				done()
+				p.running.Add(-1)
+				fmt.Println("Batch cancelled - Email processing stopped")
+				return
+			}
+		}
+
+		EmailSentEach := time.Now()
+		sent := false
+		var messageID string
+		if err == nil {
+			messageID, err = service.SendEmailUsingWorker(conn, &email)
+			sent = true
+		}
+		elapsed := time.Since(EmailSentEach)
+		done()
+
+		res := &model.EmailResult{Index: job.index, Email: email.SentTo, StartedAt: EmailSentEach, FinishedAt: EmailSentEach.Add(elapsed)}
+		if err != nil {
+			res.Status = "failed"
+			res.Error = err.Error()
+			res.SMTPCode = service.SMTPCode(err)
+		} else {
+			res.Status = "success"
+			res.SMTPCode = 250 // The server accepted the message data
+			res.MessageID = messageID
+		}
+
+		throttled := sent && service.IsThrottled(err)
+		if sent {
+			p.observe(elapsed, throttled)
+		}
+
+		if !p.sendResult(res) {
+			p.running.Add(-1)
+			return
+		}
+		fmt.Println("Each time taken is", elapsed)
+
+		// A throttling reply usually ends the session, and any other failure
+		// may leave it mid-transaction: reset it or hand over to a fresh worker.
+		if throttled || (sent && err != nil && conn.Reset() != nil) {
+			p.running.Add(-1)
+			fmt.Printf("Worker %d: leaving after SMTP error: %v\n", workerID, err)
+			return
+		}
+
+		// Give the connection back when other senders are waiting for one.
+		// The last worker queues again right away so the batch keeps its turn.
+		if slot.ShouldYield() {
+			fmt.Printf("Worker %d: yielding its SMTP connection\n", workerID)
+			if p.running.Add(-1) == 0 {
+				p.spawnWaiting()
+			}
+			return
+		}
+	}
+}
+
+// locale resolves the locale an entry's layout is rendered in: its locale
+// field, then the languages of the batch request's Accept-Language header,
+// then its form's locale from FORMS_FILE, then DEFAULT_LOCALE.
+func (p *batchPool) locale(email *model.Email) *template.Locale {
+	preferences := append([]string{email.Locale}, p.languages...)
+	preferences = append(preferences, config.EnvVar.Form(email.ProductName).Locale, config.EnvVar.DefaultLocale)
+	return template.ResolveLocale(preferences...)
+}
+
+// observe records the outcome of one send for the next scaling decision.
+func (p *batchPool) observe(elapsed time.Duration, throttled bool) {
+	p.sends.Add(1)
+	p.latencyNs.Add(elapsed.Nanoseconds())
+	if throttled {
+		p.throttled.Add(1)
+		monitoring.RecordSMTPThrottle()
+	}
+}
+
+// control periodically resizes the pool until every entry has been picked up.
+func (p *batchPool) control() {
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-p.jobs.Drained():
+			return // Remaining workers finish their current email and exit
+		case <-ticker.C:
+		}
+
+		p.rebalance()
+	}
+}
+
+// rebalance applies one scaling decision from the last window's observations
+// and tops the pool up to its target.
+func (p *batchPool) rebalance() {
+	sends := p.sends.Swap(0)
+	latency := time.Duration(p.latencyNs.Swap(0))
+	throttled := p.throttled.Swap(0)
+
+	target := p.target.Load()
+	newTarget, reason := target, ""
+
+	switch {
+	case throttled > 0:
+		// The server is pushing back: halve the pressure straight away
+		newTarget, reason = max(1, target/2), fmt.Sprintf("%d throttled replies", throttled)
+
+	case sends > 0:
+		average := latency / time.Duration(sends)
+		if p.baseline == 0 {
+			p.baseline = average
+		}
+
+		if average > 2*p.baseline && target > 1 {
+			newTarget, reason = target-1, fmt.Sprintf("latency %v over baseline %v", average, p.baseline)
+		} else if ready := p.jobs.Ready(); ready > int(target) && target < p.maxWorkers && average <= p.baseline*3/2 {
+			newTarget, reason = target+1, fmt.Sprintf("%d entries ready", ready)
+		}
+	}
+
+	if newTarget != target {
+		p.target.Store(newTarget)
+		monitoring.RecordWorkerScale(int(newTarget - target))
+		fmt.Printf("Batch pool: %d -> %d workers (%s)\n", target, newTarget, reason)
+	}
+
+	// Replace workers that left, and add the ones scaled up, as far as the
+	// shared SMTP slots allow. A pool left without workers queues for a slot.
+	if p.running.Load() == 0 {
+		p.spawnWaiting()
+	}
+	for p.running.Load() < p.target.Load() {
+		if !p.trySpawn() {
+			break
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/model"
+	"Form-Mailly-Go/internal/template"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchPoolLocale(t *testing.T) {
+	withConfig(t, &config.EnvironmentVariable{
+		DefaultLocale: "es",
+		Forms:         map[string]config.FormSettings{"Shop": {Locale: "de"}},
+	})
+
+	cases := map[string]struct {
+		acceptLanguage string
+		email          model.Email
+		want           string
+	}{
+		"Entry locale first":          {acceptLanguage: "fr", email: model.Email{Locale: "de-AT"}, want: "de"},
+		"Then Accept-Language":        {acceptLanguage: "xx, fr;q=0.5", email: model.Email{ProductName: "Shop"}, want: "fr"},
+		"Then the form's locale":      {email: model.Email{ProductName: "Shop"}, want: "de"},
+		"Then DEFAULT_LOCALE":         {email: model.Email{ProductName: "Other"}, want: "es"},
+		"Unknown languages fall back": {acceptLanguage: "xx", email: model.Email{Locale: "yy"}, want: "es"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			pool := &batchPool{languages: template.ParseAcceptLanguage(tc.acceptLanguage)}
+			if got := pool.locale(&tc.email).Tag; got != tc.want {
+				t.Errorf("locale() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBatchPoolRebalance(t *testing.T) {
+	withConfig(t, &config.EnvironmentVariable{})
+
+	const baseline = 100 * time.Millisecond
+	cases := map[string]struct {
+		target, maxWorkers int32
+		ready              int           // Entries waiting for a worker
+		latency            time.Duration // Of every send in the window
+		throttled          int           // Sends answered with 421/451
+		want               int32
+	}{
+		"Scales up while sends stay fast":      {target: 2, maxWorkers: 5, ready: 10, latency: baseline, want: 3},
+		"Holds when workers keep up":           {target: 2, maxWorkers: 5, ready: 2, latency: baseline, want: 2},
+		"Holds when latency rises a little":    {target: 2, maxWorkers: 5, ready: 10, latency: 2 * baseline, want: 2},
+		"Stays within BATCH_WORKERS_PER_BATCH": {target: 5, maxWorkers: 5, ready: 10, latency: baseline, want: 5},
+		"Scales down on high latency":          {target: 3, maxWorkers: 5, ready: 10, latency: 3 * baseline, want: 2},
+		"Keeps one worker on high latency":     {target: 1, maxWorkers: 5, ready: 10, latency: 3 * baseline, want: 1},
+		"Halves on throttling":                 {target: 4, maxWorkers: 5, ready: 10, latency: baseline, throttled: 1, want: 2},
+		"Keeps one worker on throttling":       {target: 1, maxWorkers: 5, ready: 10, latency: baseline, throttled: 1, want: 1},
+		"Holds without sends to judge":         {target: 2, maxWorkers: 5, ready: 10, want: 2},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			jobs := newDomainDispatcher(tc.ready)
+			for i := range tc.ready {
+				jobs.Put(context.Background(), batchJob{index: i, email: model.Email{SentTo: fmt.Sprintf("user%d@example.com", i)}})
+			}
+			pool := &batchPool{jobs: jobs, maxWorkers: tc.maxWorkers, baseline: baseline}
+			pool.target.Store(tc.target)
+			pool.running.Store(tc.maxWorkers) // No worker is started; only the target is checked
+			if tc.latency > 0 {
+				for i := range 4 {
+					pool.observe(tc.latency, i < tc.throttled)
+				}
+			}
+
+			pool.rebalance()
+			if got := pool.target.Load(); got != tc.want {
+				t.Errorf("target = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBatchPoolRebalanceSetsBaseline(t *testing.T) {
+	withConfig(t, &config.EnvironmentVariable{})
+
+	pool := &batchPool{jobs: newDomainDispatcher(1), maxWorkers: 5}
+	pool.target.Store(1)
+	pool.running.Store(5)
+	pool.observe(100*time.Millisecond, false)
+	pool.observe(300*time.Millisecond, false)
+
+	pool.rebalance()
+	if pool.baseline != 200*time.Millisecond {
+		t.Errorf("baseline = %v, want the first window's average of 200ms", pool.baseline)
+	}
+}
+
+func TestBatchPoolRetire(t *testing.T) {
+	pool := &batchPool{}
+	pool.target.Store(2)
+	pool.running.Store(4)
+
+	var retired int
+	for pool.retire() {
+		retired++
+	}
+	if retired != 2 || pool.running.Load() != 2 {
+		t.Errorf("retired %d workers leaving %d, want 2 leaving 2", retired, pool.running.Load())
+	}
+}
+
+func TestBatchPoolSpawnsWaitingWorkerWhenEmpty(t *testing.T) {
+	withBatchConfig(t)
+
+	jobs := newDomainDispatcher(1)
+	jobs.Put(context.Background(), batchJob{email: model.Email{SentTo: "a@example.com"}})
+	jobs.Close()
+
+	var wg sync.WaitGroup
+	results := make(chan *model.EmailResult, 1)
+	pool := &batchPool{
+		batchID:    "pool-test",
+		ctx:        context.Background(),
+		jobs:       jobs,
+		maxWorkers: 2,
+		wg:         &wg,
+		sendResult: func(result *model.EmailResult) bool {
+			results <- result
+			return true
+		},
+	}
+	pool.target.Store(1)
+
+	// Every worker has left, so the pool queues for a slot; the worker cannot
+	// reach the SMTP server and reports the entry as failed
+	pool.rebalance()
+	wg.Wait()
+	if result := <-results; result.Status != "failed" || result.Email != "a@example.com" {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if running := pool.running.Load(); running != 0 {
+		t.Errorf("%d workers still counted after the batch finished", running)
+	}
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"Form-Mailly-Go/internal/template"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Content types accepted by the batch endpoint in addition to a JSON array.
+const (
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeCSV    = "text/csv"
+)
+
+// emailSource yields batch entries one at a time, so the processor never has to
+// hold more than a handful of them in memory.
+type emailSource interface {
+	// Next returns the next entry, or io.EOF once the input is exhausted.
+	Next() (model.Email, error)
+}
+
+// isStreamingContentType reports whether the request body should be decoded
+// incrementally (NDJSON or CSV) instead of as a single JSON array.
+func isStreamingContentType(request *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == contentTypeNDJSON || mediaType == contentTypeCSV
+}
+
+// newStreamingSource builds an incremental decoder for NDJSON or CSV bodies.
+// Entries are read lazily while the workers are already sending.
+func newStreamingSource(request *http.Request) (emailSource, error) {
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	switch mediaType {
+	case contentTypeNDJSON:
+		return &ndjsonSource{decoder: json.NewDecoder(request.Body)}, nil
+	case contentTypeCSV:
+		return newCSVSource(request.Body)
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+}
+
+// jsonBatch is a decoded JSON batch body.
+type jsonBatch struct {
+	emails      []model.Email
+	merge       *template.MailMerge // Set for mail-merge objects
+	callbackURL string              // Only mail-merge objects can carry one in the body
+}
+
+// decodeJSONBatch reads a JSON batch body, which is either an array of entries
+// or a mail-merge object sharing one subject and message template. Entries are
+// returned unvalidated. A body over the size limit fails with the
+// *http.MaxBytesError, so the caller can answer 413.
+func decodeJSONBatch(body io.Reader) (*jsonBatch, error) {
+	reader := bufio.NewReader(body)
+	if !startsWithObject(reader) {
+		// Json to object Processing
+		var emailList []model.Email
+		if err := json.NewDecoder(reader).Decode(&emailList); err != nil {
+			return nil, invalidJSON(err)
+		}
+		return &jsonBatch{emails: emailList}, nil
+	}
+
+	var batch model.MailMergeBatch
+	if err := json.NewDecoder(reader).Decode(&batch); err != nil {
+		return nil, invalidJSON(err)
+	}
+
+	merge, err := template.NewMailMerge(batch.Subject, batch.Message, batch.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every recipient shares the template, so validation sees the unrendered source
+	for i := range batch.Recipients {
+		recipient := &batch.Recipients[i]
+		recipient.Subject = batch.Subject
+		recipient.Message = batch.Message
+		recipient.Format, recipient.Template = batch.Format, batch.Template
+		if recipient.ProductName == "" {
+			recipient.ProductName = batch.ProductName
+		}
+		if recipient.Locale == "" {
+			recipient.Locale = batch.Locale
+		}
+	}
+	return &jsonBatch{emails: batch.Recipients, merge: merge, callbackURL: batch.CallbackURL}, nil
+}
+
+// invalidJSON hides decoder details from clients but keeps a body size error intact.
+func invalidJSON(err error) error {
+	if _, tooLarge := bodyTooLarge(err); tooLarge {
+		return err
+	}
+	return errors.New("Invalid JSON format")
+}
+
+// startsWithObject reports whether the next non-whitespace byte opens a JSON object.
+func startsWithObject(reader *bufio.Reader) bool {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return false
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		_ = reader.UnreadByte()
+		return b == '{'
+	}
+}
+
+// sliceSource serves entries from an already decoded JSON array.
+type sliceSource struct {
+	emails []model.Email
+	next   int
+}
+
+func (s *sliceSource) Next() (model.Email, error) {
+	if s.next >= len(s.emails) {
+		return model.Email{}, io.EOF
+	}
+	email := s.emails[s.next]
+	s.emails[s.next] = model.Email{} // Let the entry be collected once it is handed out
+	s.next++
+	return email, nil
+}
+
+// ndjsonSource decodes one JSON object per line.
+type ndjsonSource struct {
+	decoder *json.Decoder
+	line    int
+}
+
+func (s *ndjsonSource) Next() (model.Email, error) {
+	var email model.Email
+	s.line++
+	if err := s.decoder.Decode(&email); err != nil {
+		if errors.Is(err, io.EOF) {
+			return model.Email{}, io.EOF
+		}
+		if _, tooLarge := bodyTooLarge(err); tooLarge {
+			return model.Email{}, err
+		}
+		return model.Email{}, fmt.Errorf("entry %d: invalid JSON", s.line)
+	}
+	return email, nil
+}
+
+// csvSource decodes CSV rows. The first row is a header naming the columns,
+// which may appear in any order; unknown columns are ignored.
+type csvSource struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVSource(body io.Reader) (*csvSource, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["sent_to"]; !ok {
+		return nil, fmt.Errorf("CSV header must contain a %q column", "sent_to")
+	}
+
+	return &csvSource{reader: reader, columns: columns}, nil
+}
+
+func (s *csvSource) Next() (model.Email, error) {
+	record, err := s.reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return model.Email{}, io.EOF
+		}
+		return model.Email{}, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	value := func(column string) string {
+		if i, ok := s.columns[column]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	return model.Email{
+		SentTo:      value("sent_to"),
+		Subject:     value("subject"),
+		Message:     value("message"),
+		ProductName: value("product_name"),
+		Format:      value("format"),
+		Template:    value("template"),
+		Locale:      value("locale"),
+	}, nil
+}
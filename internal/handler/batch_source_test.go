@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStreamingSource(t *testing.T) {
+	cases := map[string]struct {
+		contentType string
+		body        string
+		want        []model.Email
+		wantErr     string
+	}{
+		"NDJSON": {
+			contentType: "application/x-ndjson",
+			body: `{"sent_to":"a@example.com","subject":"Hi","message":"One"}
+{"sent_to":"b@example.com","subject":"Hi","message":"Two"}
+`,
+			want: []model.Email{
+				{SentTo: "a@example.com", Subject: "Hi", Message: "One"},
+				{SentTo: "b@example.com", Subject: "Hi", Message: "Two"},
+			},
+		},
+		"NDJSON malformed line": {
+			contentType: "application/x-ndjson",
+			body:        `{"sent_to":"a@example.com","subject":"Hi","message":"One"}` + "\n{oops\n",
+			want:        []model.Email{{SentTo: "a@example.com", Subject: "Hi", Message: "One"}},
+			wantErr:     "entry 2: invalid JSON",
+		},
+		"CSV with reordered columns": {
+			contentType: "text/csv; charset=utf-8",
+			body:        "message,sent_to,subject\n\"Hello, there\",a@example.com,Hi\n",
+			want:        []model.Email{{SentTo: "a@example.com", Subject: "Hi", Message: "Hello, there"}},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", "/api/batch/contact", strings.NewReader(tc.body))
+			request.Header.Set("Content-Type", tc.contentType)
+
+			if !isStreamingContentType(request) {
+				t.Fatalf("isStreamingContentType() = false, want true")
+			}
+			source, err := newStreamingSource(request)
+			if err != nil {
+				t.Fatalf("newStreamingSource() error = %v", err)
+			}
+
+			var got []model.Email
+			var gotErr string
+			for {
+				email, err := source.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					gotErr = err.Error()
+					break
+				}
+				got = append(got, email)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d entries, want %d", len(got), len(tc.want))
+			}
+			for i := range got {
+				if !reflect.DeepEqual(got[i], tc.want[i]) {
+					t.Errorf("entry %d = %+v, want %+v", i, got[i], tc.want[i])
+				}
+			}
+			if gotErr != tc.wantErr {
+				t.Errorf("error = %q, want %q", gotErr, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCSVSourceRequiresSentToColumn(t *testing.T) {
+	if _, err := newCSVSource(strings.NewReader("email,subject,message\n")); err == nil {
+		t.Error("Expected an error for a header without sent_to")
+	}
+}
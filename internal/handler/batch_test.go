@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/model"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withBatchConfig sets up a configuration for running whole batches. The SMTP
+// scheduler and breaker keep the first configuration they see, so every test
+// sending batches uses this one. Nothing listens on the SMTP port, so each
+// entry ends as a failed result.
+func withBatchConfig(t *testing.T) {
+	withConfig(t, &config.EnvironmentVariable{
+		SMTPHost:             "127.0.0.1",
+		SMTPPort:             "1",
+		SMTPMaxConnections:   4,
+		SMTPMaxQueue:         4,
+		SMTPQueueTimeout:     time.Second,
+		SMTPBreakerThreshold: 1000,
+	})
+}
+
+// slowNDJSON returns a body sending count entries, one every interval.
+func slowNDJSON(count int, interval time.Duration) io.Reader {
+	reader, writer := io.Pipe()
+	go func() {
+		for i := range count {
+			time.Sleep(interval)
+			fmt.Fprintf(writer, `{"sent_to":"user%d@example.com","subject":"Hi","message":"Hello"}`+"\n", i)
+		}
+		writer.Close()
+	}()
+	return reader
+}
+
+// postStream posts body to the batch endpoint of server as NDJSON.
+func postStream(t *testing.T, server *httptest.Server, body io.Reader) *http.Response {
+	t.Helper()
+	response, err := server.Client().Post(server.URL, "application/x-ndjson", body)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	return response
+}
+
+// readSummary reads a batch stream to its end and returns the summary event.
+func readSummary(t *testing.T, response *http.Response) model.BatchSummary {
+	t.Helper()
+	defer response.Body.Close()
+
+	var summary model.BatchSummary
+	found := false
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		if scanner.Text() != "event: summary" || !scanner.Scan() {
+			continue
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(scanner.Text(), "data: ")), &summary); err != nil {
+			t.Fatalf("Invalid summary: %v", err)
+		}
+		found = true
+	}
+	if !found {
+		t.Fatalf("The stream ended without a summary")
+	}
+	return summary
+}
+
+func TestStreamedBatchOutlastsReadTimeout(t *testing.T) {
+	withBatchConfig(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(BatchEmailProcessor))
+	server.Config.ReadTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	response := postStream(t, server, slowNDJSON(4, 75*time.Millisecond))
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", response.StatusCode)
+	}
+	if summary := readSummary(t, response); summary.Total != 4 || summary.Status != "completed" {
+		t.Errorf("Expected all 4 entries to be read, got %+v", summary)
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"Form-Mailly-Go/internal/template"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Validation modes for a batch, selected with the "validation" query parameter.
+const (
+	// validationStrict rejects the whole batch at the first invalid entry (default).
+	validationStrict = "strict"
+	// validationReport checks every entry and rejects the batch with the full list of problems.
+	validationReport = "report"
+	// validationSkip sends the valid entries and reports the invalid ones as skipped in the stream.
+	validationSkip = "skip"
+)
+
+// batchValidationMode reads the validation mode from the query string.
+func batchValidationMode(request *http.Request) (string, error) {
+	switch mode := request.URL.Query().Get("validation"); mode {
+	case "", validationStrict:
+		return validationStrict, nil
+	case validationReport, validationSkip:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("validation must be one of %s, %s or %s", validationStrict, validationReport, validationSkip)
+	}
+}
+
+// validateBatchEntry checks one entry, including that it provides every
+// variable the mail-merge templates reference. It returns nil when valid.
+func validateBatchEntry(index int, email model.Email, merge *template.MailMerge) *model.ValidationError {
+	if errMsg, field := validateBatchEmailData(email); errMsg != "" {
+		return &model.ValidationError{Index: index, Field: field, Message: errMsg}
+	}
+	if err := template.CheckFormat(email.Format, template.FormatHTML, template.FormatMarkdown, template.FormatText); err != nil {
+		return &model.ValidationError{Index: index, Field: "format", Message: err.Error()}
+	}
+	if email.Template != "" {
+		if err := template.Check(email.Template); err != nil {
+			return &model.ValidationError{Index: index, Field: "template", Message: err.Error()}
+		}
+	}
+	if err := template.CheckLocale(email.Locale); err != nil {
+		return &model.ValidationError{Index: index, Field: "locale", Message: err.Error()}
+	}
+
+	if merge != nil {
+		if missing := merge.MissingVariables(email.Data); len(missing) > 0 {
+			return &model.ValidationError{
+				Index:   index,
+				Field:   "data",
+				Message: "data is missing: " + strings.Join(missing, ", "),
+			}
+		}
+	}
+	return nil
+}
+
+// validateBatch checks the entries of an upfront-decoded batch. In strict mode
+// it stops at the first problem; otherwise every invalid entry is listed.
+func validateBatch(emails []model.Email, merge *template.MailMerge, mode string) []model.ValidationError {
+	var errs []model.ValidationError
+	for i, email := range emails {
+		if verr := validateBatchEntry(i, email, merge); verr != nil {
+			errs = append(errs, *verr)
+			if mode == validationStrict {
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// writeValidationErrors rejects a batch with a summary and the structured list of problems.
+func writeValidationErrors(response http.ResponseWriter, errMsg string, errs []model.ValidationError) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusBadRequest)
+	err := json.NewEncoder(response).Encode(struct {
+		Error  string                  `json:"error"`
+		Errors []model.ValidationError `json:"errors"`
+	}{Error: errMsg, Errors: errs})
+	if err != nil {
+		return
+	}
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"reflect"
+	"testing"
+)
+
+func TestValidateBatch(t *testing.T) {
+	emails := []model.Email{
+		{SentTo: "a@example.com", Subject: "Hi", Message: "One"},
+		{SentTo: "not-an-email", Subject: "Hi", Message: "Two"},
+		{SentTo: "c@example.com", Subject: "Hi", Message: ""},
+		{SentTo: "d@example.com", Subject: "Hi", Message: "# Four", Format: "markdown"},
+		{SentTo: "e@example.com", Subject: "Hi", Message: "Five", Format: "rtf"},
+		{SentTo: "f@example.com", Subject: "Hi", Message: "Six", Template: "fancy"},
+		{SentTo: "g@example.com", Subject: "Hi", Message: "Seven", Locale: "german!"},
+	}
+
+	cases := map[string]struct {
+		mode string
+		want []model.ValidationError
+	}{
+		"Strict stops at first error": {
+			mode: validationStrict,
+			want: []model.ValidationError{
+				{Index: 1, Field: "email", Message: "email is not a valid email address"},
+			},
+		},
+		"Report lists every error": {
+			mode: validationReport,
+			want: []model.ValidationError{
+				{Index: 1, Field: "email", Message: "email is not a valid email address"},
+				{Index: 2, Field: "message", Message: "message is required"},
+				{Index: 4, Field: "format", Message: `unknown format "rtf", use one of: html, markdown, text`},
+				{Index: 5, Field: "template", Message: `unknown template "fancy", available templates: auto-reply, banner, card, gradient, message`},
+				{Index: 6, Field: "locale", Message: `invalid locale "german!", use a language tag such as "de" or "pt-BR"`},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := validateBatch(emails, nil, tc.mode)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("validateBatch() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -3,9 +3,13 @@ package handler
 import (
 	"Form-Mailly-Go/internal/model"
 	"Form-Mailly-Go/internal/service"
+	"Form-Mailly-Go/internal/template"
 	"Form-Mailly-Go/internal/validation"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 )
 
 func ContactHandler(response http.ResponseWriter, request *http.Request) {
@@ -29,11 +33,24 @@ func ContactHandler(response http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	if err := service.Send(&form); err != nil {
+	form.AcceptLanguage = request.Header.Get("Accept-Language")
+	form.ReceivedAt = time.Now()
+
+	if err := service.Send(request.Context(), &form); err != nil {
+		if errors.Is(err, service.ErrSMTPSaturated) || errors.Is(err, service.ErrSendQuotaExceeded) ||
+			errors.Is(err, service.ErrSMTPUnavailable) {
+			writeSaturated(response, err)
+			return
+		}
 		http.Error(response, `{"error": "Failed to send email"}`, http.StatusInternalServerError)
 		return
 	}
 
+	// The submission is delivered either way, so a missing confirmation is only logged
+	if err := service.SendAutoReply(request.Context(), &form); err != nil {
+		fmt.Printf("Form %q: auto-reply not sent: %v\n", form.ProductName, err)
+	}
+
 	response.WriteHeader(http.StatusCreated)
 	_, err := response.Write([]byte(`{"message": "Email sent successfully"}`))
 	if err != nil {
@@ -75,6 +92,7 @@ func validateContactForm(form *model.ContactForm) string {
 			Value: &form.Message,
 			Rules: []validation.Rule{
 				validation.RequiredRule(),
+				validation.MaxLengthRule(20000),
 			},
 		},
 		{
@@ -101,5 +119,18 @@ func validateContactForm(form *model.ContactForm) string {
 		}
 	}
 
+	if form.Template != "" {
+		if err := template.Check(form.Template); err != nil {
+			return err.Error()
+		}
+	}
+	// Visitors never get to send HTML of their own
+	if err := template.CheckFormat(form.Format, template.FormatText, template.FormatMarkdown); err != nil {
+		return err.Error()
+	}
+	if err := template.CheckLocale(form.Locale); err != nil {
+		return err.Error()
+	}
+
 	return "" // no error found, valid form
 }
@@ -42,6 +42,45 @@ func TestValidateContactForm(t *testing.T) {
 			},
 			wantError: "name must be less than or equal to 100 characters",
 		},
+		"Message too long": {
+			form: model.ContactForm{
+				Name:    "Alice",
+				Email:   "alice@example.com",
+				Subject: "Hi",
+				Message: stringOfLength(20001),
+			},
+			wantError: "message must be less than or equal to 20000 characters",
+		},
+		"Unknown template": {
+			form: model.ContactForm{
+				Name:     "Alice",
+				Email:    "alice@example.com",
+				Subject:  "Hi",
+				Message:  "Short message",
+				Template: "fancy",
+			},
+			wantError: `unknown template "fancy", available templates: auto-reply, banner, card, gradient, message`,
+		},
+		"HTML format": {
+			form: model.ContactForm{
+				Name:    "Alice",
+				Email:   "alice@example.com",
+				Subject: "Hi",
+				Message: "<b>Short</b> message",
+				Format:  "html",
+			},
+			wantError: `unknown format "html", use one of: text, markdown`,
+		},
+		"Invalid locale": {
+			form: model.ContactForm{
+				Name:    "Alice",
+				Email:   "alice@example.com",
+				Subject: "Hi",
+				Message: "Short message",
+				Locale:  "german",
+			},
+			wantError: `invalid locale "german", use a language tag such as "de" or "pt-BR"`,
+		},
 		"Whitespace + bad email + long subject + empty message": {
 			form: model.ContactForm{
 				Name:    "   ",
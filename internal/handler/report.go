@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/report"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// reports is the batch report store, or nil when REPORT_DIR is empty.
+var reports = sync.OnceValue(func() *report.Store {
+	if config.EnvVar.ReportDir == "" {
+		return nil
+	}
+	return report.NewStore(config.EnvVar.ReportDir, config.EnvVar.ReportRetention)
+})
+
+// startReport begins recording a batch's results. It returns nil, which
+// records nothing, when reports are disabled or cannot be written.
+func startReport(batchID string) *report.Recorder {
+	store := reports()
+	if store == nil {
+		return nil
+	}
+	recorder, err := store.Create(batchID)
+	if err != nil {
+		fmt.Printf("Batch %s: report not recorded: %v\n", batchID, err)
+		return nil
+	}
+	return recorder
+}
+
+// BatchReportHandler serves the stored results of a batch for
+// GET /api/batch/{id}/report?format=json|csv. A batch that is still running
+// returns what has finished so far, with a null summary.
+func BatchReportHandler(response http.ResponseWriter, request *http.Request) {
+	store := reports()
+	if store == nil {
+		writeErrorJSON(response, http.StatusNotFound, "Batch reports are disabled")
+		return
+	}
+
+	format := request.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		writeErrorJSON(response, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+
+	id := request.PathValue("id")
+	batchReport, err := store.Open(id)
+	if errors.Is(err, report.ErrNotFound) {
+		writeErrorJSON(response, http.StatusNotFound, "No report found for this batch")
+		return
+	}
+	if err != nil {
+		writeErrorJSON(response, http.StatusInternalServerError, "Failed to read the report")
+		return
+	}
+	defer batchReport.Close()
+
+	// Large reports may take longer to download than WRITE_TIMEOUT allows
+	_ = http.NewResponseController(response).SetWriteDeadline(time.Time{})
+	response.Header().Set("Cache-Control", "no-cache")
+	if format == "csv" {
+		response.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		response.Header().Set("Content-Disposition", `attachment; filename="batch-`+id+`.csv"`)
+		err = batchReport.WriteCSV(response)
+	} else {
+		response.Header().Set("Content-Type", "application/json")
+		err = batchReport.WriteJSON(response, id)
+	}
+	if err != nil {
+		// Headers are gone by now; all that is left is to note it
+		fmt.Printf("Batch %s: report download failed: %v\n", id, err)
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrServerShutdown is the cancellation cause the server gives request contexts
+// once its shutdown deadline has passed. Batches seeing it stop starting new
+// emails, report what already finished and close the stream with a summary.
+var ErrServerShutdown = errors.New("server is shutting down")
+
+var (
+	// detachedCtx is the parent of work that outlives its request, such as
+	// batches reporting through callback_url and webhook deliveries. Only a
+	// server shutdown cancels it.
+	detachedCtx, interruptDetached = context.WithCancelCause(context.Background())
+	detachedWG                     sync.WaitGroup
+)
+
+// interrupted reports whether ctx was cancelled by a server shutdown.
+func interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrServerShutdown)
+}
+
+// runDetached runs f in the background, tracked so shutdown can wait for it.
+func runDetached(f func(ctx context.Context)) {
+	detachedWG.Go(func() { f(detachedCtx) })
+}
+
+// WaitDetached waits for background batches and webhook deliveries to finish,
+// returning ctx's error if it ends first.
+func WaitDetached(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		detachedWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// InterruptDetached stops background batches after their current email, the
+// way ErrServerShutdown stops request-bound ones, and ends webhook retries.
+func InterruptDetached() {
+	interruptDetached(ErrServerShutdown)
+}
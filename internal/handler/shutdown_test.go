@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainsRunningBatches(t *testing.T) {
+	withBatchConfig(t)
+	server := httptest.NewServer(http.HandlerFunc(BatchEmailProcessor))
+	defer server.Close()
+
+	response := postStream(t, server, slowNDJSON(4, 100*time.Millisecond))
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", response.StatusCode)
+	}
+
+	drained := make(chan error)
+	go func() { drained <- server.Config.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond) // Let the server stop listening
+
+	if late, err := server.Client().Post(server.URL, "application/x-ndjson", slowNDJSON(1, 0)); err == nil {
+		late.Body.Close()
+		t.Errorf("Expected a batch posted during the drain to be refused, got %d", late.StatusCode)
+	}
+	if summary := readSummary(t, response); summary.Total != 4 || summary.Status != "completed" {
+		t.Errorf("Expected the running batch to finish, got %+v", summary)
+	}
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("Shutdown() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after the batch finished")
+	}
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"Form-Mailly-Go/internal/service"
+	"Form-Mailly-Go/internal/template"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// TemplatesHandler lists the email templates a contact submission can pick
+// with its "template" field, and the one used when it picks none.
+func TemplatesHandler(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-cache")
+
+	err := json.NewEncoder(response).Encode(struct {
+		Templates []string `json:"templates"`
+		Default   string   `json:"default"`
+	}{
+		Templates: template.Names(),
+		Default:   service.ContactTemplate(&model.ContactForm{}),
+	})
+	if err != nil {
+		http.Error(response, `{"error": "Failed to encode templates"}`, http.StatusInternalServerError)
+	}
+}
+
+// TemplatePreviewHandler renders a template with a sample submission for
+// GET /api/templates/{name}/preview?format=html|text|mime, as contact mail
+// would be sent right now, without sending anything. The locale comes from
+// ?locale= or the Accept-Language header, as for a real submission.
+func TemplatePreviewHandler(response http.ResponseWriter, request *http.Request) {
+	format := request.URL.Query().Get("format")
+	if format == "" {
+		format = "html"
+	}
+	if format != "html" && format != "text" && format != "mime" {
+		writeErrorJSON(response, http.StatusBadRequest, "format must be html, text or mime")
+		return
+	}
+	name := request.PathValue("name")
+	if err := template.Check(name); err != nil {
+		writeErrorJSON(response, http.StatusNotFound, err.Error())
+		return
+	}
+
+	form := template.SampleContact()
+	form.Locale = request.URL.Query().Get("locale")
+	if err := template.CheckLocale(form.Locale); err != nil {
+		writeErrorJSON(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	form.AcceptLanguage = request.Header.Get("Accept-Language")
+	form.ReceivedAt = time.Now()
+
+	message, raw, err := service.PreviewTemplate(name, form)
+	if err != nil {
+		writeErrorJSON(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	headers := response.Header()
+	headers.Set("Cache-Control", "no-cache")
+	headers.Add("Vary", "Accept-Language")
+	switch format {
+	case "html":
+		// Email HTML may load remote images, but never runs scripts
+		headers.Set("Content-Security-Policy", "default-src 'none'; img-src * data:; style-src 'unsafe-inline'; sandbox")
+		headers.Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = response.Write([]byte(message.HTML))
+	case "text":
+		headers.Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = response.Write([]byte(message.Text))
+	case "mime":
+		headers.Set("Content-Type", "text/plain; charset=utf-8") // Shown as text rather than opened as a message
+		_, _ = response.Write(raw)
+	}
+}
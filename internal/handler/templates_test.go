@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTemplatePreviewHandler(t *testing.T) {
+	withConfig(t, &config.EnvironmentVariable{SenderEmail: "forms@example.com", ReceiverEmail: "team@example.com"})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/templates/{name}/preview", TemplatePreviewHandler)
+
+	tests := []struct {
+		name        string
+		url         string
+		language    string
+		wantStatus  int
+		wantType    string
+		wantContain []string
+	}{
+		{"HTML", "/api/templates/card/preview", "", http.StatusOK, "text/html", []string{"Ada Lovelace", "New Contact Request"}},
+		{"Text", "/api/templates/gradient/preview?format=text", "", http.StatusOK, "text/plain", []string{"Ada Lovelace", "Subject:"}},
+		{"MIME", "/api/templates/auto-reply/preview?format=mime", "", http.StatusOK, "text/plain",
+			[]string{"From: Example Product <forms@example.com>\r\nTo: team@example.com\r\n", "Subject: We received your message: Question about your product\r\n", "multipart/alternative", "Content-Transfer-Encoding: quoted-printable"}},
+		{"Locale field", "/api/templates/banner/preview?locale=fr", "de", http.StatusOK, "text/html", []string{"Nom:", `lang="fr"`}},
+		{"Accept-Language", "/api/templates/card/preview", "de-CH, en;q=0.5", http.StatusOK, "text/html", []string{"Neue Kontaktanfrage"}},
+		{"Unknown template", "/api/templates/fancy/preview", "", http.StatusNotFound, "application/json", []string{`unknown template \"fancy\"`}},
+		{"Unknown format", "/api/templates/card/preview?format=pdf", "", http.StatusBadRequest, "application/json", []string{"format must be html, text or mime"}},
+		{"Invalid locale", "/api/templates/card/preview?locale=german", "", http.StatusBadRequest, "application/json", []string{"invalid locale"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.language != "" {
+				request.Header.Set("Accept-Language", tt.language)
+			}
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("Status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.wantType) {
+				t.Errorf("Content-Type = %q, want %s", got, tt.wantType)
+			}
+			for _, want := range tt.wantContain {
+				if !strings.Contains(recorder.Body.String(), want) {
+					t.Errorf("Body lacks %q:\n%s", want, recorder.Body)
+				}
+			}
+		})
+	}
+}
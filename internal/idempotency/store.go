@@ -0,0 +1,314 @@
+package idempotency
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// HeaderKey is the request header clients use to mark retries of the same submission.
+	HeaderKey = "Idempotency-Key"
+
+	// HeaderReplayed is set on responses served from the store instead of the handler.
+	HeaderReplayed = "Idempotent-Replayed"
+
+	// maxKeyLength guards the store against oversized keys.
+	maxKeyLength = 255
+
+	// maxRecordedBytes caps how much of a response is kept for replay. Larger
+	// responses (e.g. huge batch streams) are remembered but cannot be replayed.
+	maxRecordedBytes = 4 << 20 // 4MB
+
+	// maxFingerprintBytes is how much of a request body is compared between
+	// requests sharing a key, so checking a retry never reads a body unbounded.
+	maxFingerprintBytes = 32 << 20 // 32MB
+
+	// entryOverhead approximates what an entry costs beyond its key, headers and body.
+	entryOverhead = 256
+)
+
+// Store remembers the outcome of requests carrying an Idempotency-Key header
+// for a fixed window, or until the outcomes kept take more than maxBytes, when
+// the least recently used ones are forgotten first. The store is in memory,
+// so on AWS Lambda it only spans requests served by the same warm instance.
+type Store struct {
+	ttl       time.Duration
+	maxBytes  int
+	mu        sync.Mutex
+	entries   map[string]*entry
+	recent    *list.List // Keys of stored outcomes, most recently used first
+	usedBytes int        // Size of the stored outcomes
+	lastSweep time.Time
+}
+
+// entry is the recorded outcome of the first request seen for a key.
+type entry struct {
+	done        chan struct{} // Closed once the first request has finished
+	fingerprint [sha256.Size]byte
+	status      int
+	header      http.Header
+	body        []byte
+	truncated   bool // The response was too large to keep
+	stored      bool // The outcome was kept for replay, even if it has been evicted since
+	expiresAt   time.Time
+	element     *list.Element // Its place in Store.recent once stored
+	size        int
+}
+
+// NewStore returns a store keeping outcomes for ttl, in at most maxBytes.
+func NewStore(ttl time.Duration, maxBytes int) *Store {
+	return &Store{
+		ttl:       ttl,
+		maxBytes:  maxBytes,
+		entries:   make(map[string]*entry),
+		recent:    list.New(),
+		lastSweep: time.Now(),
+	}
+}
+
+// Middleware makes next idempotent for requests that send an Idempotency-Key.
+// The first request runs normally and its response is stored; replays get the
+// stored response without running next again, and concurrent duplicates wait
+// for the in-flight request to finish. Server errors (5xx) and 429 responses
+// are not stored, so a retry after a failed or rejected send tries again.
+// A key is bound to the method, URL and body of its first request: reusing it
+// for a different request gets 422 instead of the other request's response.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		key := request.Header.Get(HeaderKey)
+		if key == "" {
+			next.ServeHTTP(response, request)
+			return
+		}
+		if len(key) > maxKeyLength {
+			http.Error(response, `{"error": "Idempotency-Key must be at most 255 characters"}`, http.StatusBadRequest)
+			return
+		}
+
+		// Keys are scoped to the endpoint, so one key cannot replay another endpoint's response
+		scopedKey := request.Method + " " + request.URL.Path + " " + key
+
+		for {
+			e, first := s.begin(scopedKey)
+			if first {
+				s.record(scopedKey, e, response, request, next)
+				return
+			}
+
+			select {
+			case <-e.done:
+			case <-request.Context().Done():
+				return // Client gave up waiting
+			}
+
+			if s.stored(scopedKey, e) {
+				if newHashedBody(request).sum() != e.fingerprint {
+					http.Error(response, `{"error": "Idempotency-Key was already used for a different request"}`, http.StatusUnprocessableEntity)
+					return
+				}
+				replay(response, e)
+				return
+			}
+			// The first attempt was not stored (server error or 429), so run again.
+			// An outcome evicted while this request waited is still replayed, as
+			// its handler has already run.
+		}
+	})
+}
+
+// begin returns the live entry for key, creating it when none exists.
+// first reports whether the caller created it and must run the handler.
+func (s *Store) begin(key string) (e *entry, first bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.sweep(now)
+	}
+
+	if e, ok := s.entries[key]; ok {
+		if e.expiresAt.IsZero() || now.Before(e.expiresAt) {
+			return e, false
+		}
+		s.remove(key, e)
+	}
+
+	e = &entry{done: make(chan struct{})}
+	s.entries[key] = e
+	return e, true
+}
+
+// stored reports whether the finished request of e kept its outcome for
+// replay, marking it as recently used while the store still holds it.
+func (s *Store) stored(key string, e *entry) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries[key] == e && e.element != nil {
+		s.recent.MoveToFront(e.element)
+	}
+	return e.stored
+}
+
+// record runs next, streams its response to the client and keeps a copy.
+func (s *Store) record(key string, e *entry, response http.ResponseWriter, request *http.Request, next http.Handler) {
+	rec := &recorder{ResponseWriter: response, status: http.StatusOK}
+	body := newHashedBody(request)
+	request.Body = body
+
+	defer func() {
+		fingerprint := body.sum()
+		s.mu.Lock()
+		if rec.status >= http.StatusInternalServerError || rec.status == http.StatusTooManyRequests {
+			delete(s.entries, key)
+		} else {
+			e.fingerprint = fingerprint
+			e.status = rec.status
+			e.header = rec.Header().Clone()
+			e.body = rec.body.Bytes()
+			e.truncated = rec.truncated
+			e.stored = true
+			e.expiresAt = time.Now().Add(s.ttl)
+			s.keep(key, e)
+		}
+		s.mu.Unlock()
+		close(e.done)
+	}()
+
+	next.ServeHTTP(rec, request)
+}
+
+// keep adds a stored outcome to the byte budget, forgetting the least
+// recently used outcomes while the budget is exceeded. Callers must hold s.mu.
+func (s *Store) keep(key string, e *entry) {
+	e.size = len(key) + len(e.body) + entryOverhead
+	for name, values := range e.header {
+		e.size += len(name)
+		for _, value := range values {
+			e.size += len(value)
+		}
+	}
+	e.element = s.recent.PushFront(key)
+	s.usedBytes += e.size
+
+	for s.usedBytes > s.maxBytes {
+		oldest := s.recent.Back().Value.(string)
+		s.remove(oldest, s.entries[oldest])
+	}
+}
+
+// remove forgets the outcome stored for key. Callers must hold s.mu.
+func (s *Store) remove(key string, e *entry) {
+	delete(s.entries, key)
+	if e.element != nil {
+		s.recent.Remove(e.element)
+		s.usedBytes -= e.size
+	}
+}
+
+// sweep drops expired entries. Callers must hold s.mu.
+func (s *Store) sweep(now time.Time) {
+	for key, e := range s.entries {
+		if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
+			s.remove(key, e)
+		}
+	}
+	s.lastSweep = now
+}
+
+// hashedBody hashes a request's method, URL and body as the body is read,
+// up to maxFingerprintBytes of it.
+type hashedBody struct {
+	io.ReadCloser
+	hash hash.Hash
+	left int // Body bytes still to hash
+}
+
+func newHashedBody(request *http.Request) *hashedBody {
+	h := sha256.New()
+	_, _ = io.WriteString(h, request.Method+" "+request.URL.RequestURI()+"\n")
+	return &hashedBody{ReadCloser: request.Body, hash: h, left: maxFingerprintBytes}
+}
+
+func (b *hashedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	hashed := min(n, b.left)
+	b.hash.Write(p[:hashed])
+	b.left -= hashed
+	return n, err
+}
+
+// sum reads what is left of the body to hash and returns the fingerprint.
+func (b *hashedBody) sum() [sha256.Size]byte {
+	if b.left > 0 {
+		_, _ = io.Copy(io.Discard, io.LimitReader(b, int64(b.left)))
+	}
+	return [sha256.Size]byte(b.hash.Sum(nil))
+}
+
+// replay writes a stored response.
+func replay(response http.ResponseWriter, e *entry) {
+	if e.truncated {
+		http.Error(response, `{"error": "A request with this Idempotency-Key was already processed; its response is too large to replay"}`, http.StatusConflict)
+		return
+	}
+
+	headers := response.Header()
+	for name, values := range e.header {
+		headers[name] = values
+	}
+	headers.Set(HeaderReplayed, "true")
+	response.WriteHeader(e.status)
+	_, err := response.Write(e.body)
+	if err != nil {
+		return
+	}
+}
+
+// recorder passes a response through to the client while keeping a copy.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	truncated   bool
+}
+
+func (r *recorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	if !r.truncated {
+		if r.body.Len()+len(p) > maxRecordedBytes {
+			r.truncated = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Flush keeps streaming responses (SSE) working through the recorder.
+func (r *recorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
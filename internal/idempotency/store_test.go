@@ -0,0 +1,207 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareReplaysFirstResponse(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewStore(time.Hour, 1<<20).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"message": "Email sent successfully"}`))
+	}))
+
+	send := func(key string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/api/contact", nil)
+		if key != "" {
+			request.Header.Set(HeaderKey, key)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := send("abc")
+	replayed := send("abc")
+
+	if calls.Load() != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls.Load())
+	}
+	if replayed.Code != http.StatusCreated || replayed.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q, want %d %q", replayed.Code, replayed.Body.String(), first.Code, first.Body.String())
+	}
+	if replayed.Header().Get(HeaderReplayed) != "true" {
+		t.Error("Expected replay to be marked with the Idempotent-Replayed header")
+	}
+
+	send("")
+	send("")
+	if calls.Load() != 3 {
+		t.Errorf("requests without a key should always run, handler ran %d times", calls.Load())
+	}
+}
+
+func TestMiddlewareConcurrentDuplicatesWait(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := NewStore(time.Hour, 1<<20).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Write([]byte("done"))
+	}))
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request := httptest.NewRequest("POST", "/api/contact", nil)
+			request.Header.Set(HeaderKey, "same")
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			bodies[i] = recorder.Body.String()
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want 1", calls.Load())
+	}
+	for i, body := range bodies {
+		if body != "done" {
+			t.Errorf("response %d = %q, want %q", i, body, "done")
+		}
+	}
+}
+
+func TestMiddlewareRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewStore(time.Hour, 1<<20).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	for range 3 {
+		request := httptest.NewRequest("POST", "/api/contact", nil)
+		request.Header.Set(HeaderKey, "retry")
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	if calls.Load() != 2 {
+		t.Errorf("handler ran %d times, want 2 (failed attempt + successful retry)", calls.Load())
+	}
+}
+
+func TestMiddlewareRejectsKeyReuse(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewStore(time.Hour, 1<<20).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	send := func(body string) int {
+		request := httptest.NewRequest("POST", "/api/contact", strings.NewReader(body))
+		request.Header.Set(HeaderKey, "reused")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := send(`{"message": "first"}`); code != http.StatusCreated {
+		t.Fatalf("first request = %d, want %d", code, http.StatusCreated)
+	}
+	if code := send(`{"message": "first"}`); code != http.StatusCreated {
+		t.Errorf("retry with the same body = %d, want the replayed %d", code, http.StatusCreated)
+	}
+	if code := send(`{"message": "second"}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("same key with another body = %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want 1", calls.Load())
+	}
+}
+
+func TestMiddlewareForgetsLeastRecentlyUsed(t *testing.T) {
+	var calls atomic.Int32
+	store := NewStore(time.Hour, 3*(entryOverhead+1100)) // Room for three of the responses below
+	handler := store.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+
+	send := func(key string) {
+		request := httptest.NewRequest("POST", "/api/contact", nil)
+		request.Header.Set(HeaderKey, key)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	send("a")
+	send("b")
+	send("c")
+	send("a") // Replayed, so "b" is now the least recently used
+	send("d")
+	if calls.Load() != 4 {
+		t.Fatalf("handler ran %d times, want 4", calls.Load())
+	}
+	if store.usedBytes > store.maxBytes || len(store.entries) != 3 {
+		t.Errorf("Expected 3 outcomes within %d bytes, got %d in %d bytes", store.maxBytes, len(store.entries), store.usedBytes)
+	}
+
+	send("a")
+	if calls.Load() != 4 {
+		t.Error("Expected the recently used outcome to be kept")
+	}
+	send("b")
+	if calls.Load() != 5 {
+		t.Error("Expected the least recently used outcome to be forgotten")
+	}
+}
+
+func TestMiddlewareReplaysOutcomeEvictedWhileWaiting(t *testing.T) {
+	var calls atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	// No outcome fits, so the first response is evicted as soon as it is stored
+	handler := NewStore(time.Hour, 0).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("done"))
+	}))
+
+	send := func() string {
+		request := httptest.NewRequest("POST", "/api/contact", nil)
+		request.Header.Set(HeaderKey, "evicted")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Body.String()
+	}
+
+	go send()
+	<-started
+	duplicate := make(chan string)
+	go func() { duplicate <- send() }()
+	time.Sleep(50 * time.Millisecond) // Let the duplicate start waiting
+	close(release)
+
+	if body := <-duplicate; body != "done" {
+		t.Errorf("duplicate = %q, want %q", body, "done")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want 1", calls.Load())
+	}
+}
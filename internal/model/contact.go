@@ -1,10 +1,17 @@
 package model
 
+import "time"
+
 type ContactForm struct {
-	Name           string `json:"name"`
-	Email          string `json:"email"`
-	Subject        string `json:"subject"`
-	Message        string `json:"message"`
-	ProductName    string `json:"product_name,omitempty"`
-	ProductWebsite string `json:"product_website,omitempty"`
+	Name           string    `json:"name"`
+	Email          string    `json:"email"`
+	Subject        string    `json:"subject"`
+	Message        string    `json:"message"`
+	ProductName    string    `json:"product_name,omitempty"`
+	ProductWebsite string    `json:"product_website,omitempty"`
+	Template       string    `json:"template,omitempty"` // Email template; defaults to the form's, see GET /api/templates
+	Format         string    `json:"format,omitempty"`   // Message format, text (default) or markdown
+	Locale         string    `json:"locale,omitempty"`   // Language of the email, such as "de"; preferred over AcceptLanguage
+	AcceptLanguage string    `json:"-"`                  // Accept-Language header the submission came with
+	ReceivedAt     time.Time `json:"-"`                  // When the request arrived; every email about it shows this time
 }
@@ -1,14 +1,79 @@
 package model
 
+import "time"
+
 type Email struct {
-	SentTo      string `json:"sent_to"`
-	Subject     string `json:"subject,omitempty"`
-	Message     string `json:"message"`
-	ProductName string `json:"product_name,omitempty"`
+	SentTo      string            `json:"sent_to"`
+	Subject     string            `json:"subject,omitempty"`
+	Message     string            `json:"message"`
+	ProductName string            `json:"product_name,omitempty"`
+	Data        map[string]string `json:"data,omitempty"`     // Mail-merge variables for this recipient
+	Format      string            `json:"format,omitempty"`   // Message format: html (default), markdown or text
+	Template    string            `json:"template,omitempty"` // Layout wrapping the message; markdown and text default to "message"
+	Locale      string            `json:"locale,omitempty"`   // Language of the layout, such as "de"; preferred over the batch's Accept-Language
+	Text        string            `json:"-"`                  // Plain-text version, derived from Message when empty
+}
+
+// MailMergeBatch shares one subject and message template across all recipients.
+// Placeholders such as {{.FirstName}} are filled from each recipient's Data.
+type MailMergeBatch struct {
+	Subject     string  `json:"subject"`
+	Message     string  `json:"message"`
+	ProductName string  `json:"product_name,omitempty"`
+	Format      string  `json:"format,omitempty"`
+	Template    string  `json:"template,omitempty"`
+	Locale      string  `json:"locale,omitempty"` // For recipients without their own
+	Recipients  []Email `json:"recipients"`
+	CallbackURL string  `json:"callback_url,omitempty"` // Receives the summary once the batch finishes
 }
 
 type EmailResult struct {
-	Email  string `json:"email"`
-	Status string `json:"status"`
-	Error  string `json:"error,omitempty"`
+	Index      int       `json:"index"` // Position of the entry in the submitted batch
+	Email      string    `json:"email"`
+	Status     string    `json:"status"` // success, failed or skipped
+	Field      string    `json:"field,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	SMTPCode   int       `json:"smtp_code,omitempty"`  // Final SMTP reply, when the server was reached
+	MessageID  string    `json:"message_id,omitempty"` // Message-ID header of the sent email
+	StartedAt  time.Time `json:"started_at,omitzero"`  // Unset for entries that were never sent
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+}
+
+// ValidationError describes why one entry of a batch was rejected.
+type ValidationError struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// BatchSummary is streamed as the final event of a batch.
+type BatchSummary struct {
+	BatchID    string `json:"batch_id"`
+	Status     string `json:"status"` // completed, interrupted when the server shut down mid-batch, or cancelled when the client went away
+	Total      int    `json:"total"`
+	Sent       int    `json:"sent"`
+	Failed     int    `json:"failed"`
+	Skipped    int    `json:"skipped"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Add counts one result towards the summary.
+func (s *BatchSummary) Add(result *EmailResult) {
+	s.Total++
+	switch result.Status {
+	case "success":
+		s.Sent++
+	case "skipped":
+		s.Skipped++
+	default:
+		s.Failed++
+	}
+}
+
+// BatchCallback is POSTed to a batch's callback_url once it finishes.
+type BatchCallback struct {
+	Event string `json:"event"` // Always "batch.finished"
+	BatchSummary
+	Failures          []EmailResult `json:"failures"`                     // Failed entries, oldest first
+	FailuresTruncated bool          `json:"failures_truncated,omitempty"` // More entries failed than are listed
 }
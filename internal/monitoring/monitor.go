@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -23,6 +24,16 @@ type PerformanceMonitor struct {
 	emailsFailed   int64
 	memoryPeak     int64
 	goroutinesPeak int64
+
+	// Batch worker pools
+	batchWorkers     int64
+	workerScaleUps   int64
+	workerScaleDowns int64
+	smtpThrottled    int64
+
+	// Process-wide SMTP connection slots
+	smtpConnections int64
+	smtpQueued      int64
 }
 
 var globalMonitor = &PerformanceMonitor{
@@ -30,6 +41,35 @@ var globalMonitor = &PerformanceMonitor{
 	minLatency: int64(^uint64(0) >> 1), // Max int64 value
 }
 
+// SendQuota is the outbound quota left for one sender profile. Windows without a limit are omitted.
+type SendQuota struct {
+	Second *int `json:"second,omitempty"`
+	Minute *int `json:"minute,omitempty"`
+	Day    *int `json:"day,omitempty"`
+}
+
+// quotaProvider reports the remaining send quota per sender profile, set by the service layer
+var quotaProvider atomic.Pointer[func() map[string]SendQuota]
+
+// SetQuotaProvider registers the function consulted for the send_quota metric
+func SetQuotaProvider(provider func() map[string]SendQuota) {
+	quotaProvider.Store(&provider)
+}
+
+// healthChecks are the checks other packages add to /api/health
+var (
+	healthChecksMu sync.Mutex
+	healthChecks   []func() Check
+)
+
+// RegisterHealthCheck adds a check run by every health check. A degraded or
+// unhealthy result lowers the overall status the same way.
+func RegisterHealthCheck(check func() Check) {
+	healthChecksMu.Lock()
+	defer healthChecksMu.Unlock()
+	healthChecks = append(healthChecks, check)
+}
+
 // RecordRequest records a request with its latency
 func RecordRequest(duration time.Duration, success bool) {
 	latency := duration.Nanoseconds()
@@ -67,6 +107,31 @@ func RecordEmail(success bool) {
 	}
 }
 
+// AddBatchWorkers tracks SMTP workers starting (+1) or stopping (-1) across all batches
+func AddBatchWorkers(delta int) {
+	atomic.AddInt64(&globalMonitor.batchWorkers, int64(delta))
+}
+
+// RecordWorkerScale records a batch pool growing (delta > 0) or shrinking (delta < 0)
+func RecordWorkerScale(delta int) {
+	if delta > 0 {
+		atomic.AddInt64(&globalMonitor.workerScaleUps, 1)
+	} else if delta < 0 {
+		atomic.AddInt64(&globalMonitor.workerScaleDowns, 1)
+	}
+}
+
+// RecordSMTPThrottle records a 421/451 throttling reply from the SMTP server
+func RecordSMTPThrottle() {
+	atomic.AddInt64(&globalMonitor.smtpThrottled, 1)
+}
+
+// SetSMTPConnections records how many SMTP connection slots are held and how many senders wait for one
+func SetSMTPConnections(inUse, queued int) {
+	atomic.StoreInt64(&globalMonitor.smtpConnections, int64(inUse))
+	atomic.StoreInt64(&globalMonitor.smtpQueued, int64(queued))
+}
+
 // UpdateSystemMetrics updates system-level metrics
 func UpdateSystemMetrics() {
 	var m runtime.MemStats
@@ -105,6 +170,17 @@ type Metrics struct {
 	EmailsFailed     int64         `json:"emails_failed"`
 	EmailSuccessRate float64       `json:"email_success_rate"`
 
+	// Batch worker pools
+	BatchWorkers     int64 `json:"batch_workers"`
+	WorkerScaleUps   int64 `json:"worker_scale_ups"`
+	WorkerScaleDowns int64 `json:"worker_scale_downs"`
+	SMTPThrottled    int64 `json:"smtp_throttled"`
+	SMTPConnections  int64 `json:"smtp_connections"`
+	SMTPQueued       int64 `json:"smtp_queued"`
+
+	// Remaining outbound quota per sender profile
+	SendQuota map[string]SendQuota `json:"send_quota,omitempty"`
+
 	// System metrics
 	MemoryUsage    int64  `json:"memory_usage_bytes"`
 	MemoryPeak     int64  `json:"memory_peak_bytes"`
@@ -148,6 +224,11 @@ func GetMetrics() *Metrics {
 		emailSuccessRate = float64(emailsSent) / float64(totalEmails) * 100
 	}
 
+	var sendQuota map[string]SendQuota
+	if provider := quotaProvider.Load(); provider != nil {
+		sendQuota = (*provider)()
+	}
+
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 
@@ -163,6 +244,13 @@ func GetMetrics() *Metrics {
 		EmailsSent:       emailsSent,
 		EmailsFailed:     emailsFailed,
 		EmailSuccessRate: emailSuccessRate,
+		BatchWorkers:     atomic.LoadInt64(&globalMonitor.batchWorkers),
+		WorkerScaleUps:   atomic.LoadInt64(&globalMonitor.workerScaleUps),
+		WorkerScaleDowns: atomic.LoadInt64(&globalMonitor.workerScaleDowns),
+		SMTPThrottled:    atomic.LoadInt64(&globalMonitor.smtpThrottled),
+		SMTPConnections:  atomic.LoadInt64(&globalMonitor.smtpConnections),
+		SMTPQueued:       atomic.LoadInt64(&globalMonitor.smtpQueued),
+		SendQuota:        sendQuota,
 		MemoryUsage:      int64(m.Alloc),
 		MemoryPeak:       memoryPeak,
 		Goroutines:       runtime.NumGoroutine(),
@@ -182,10 +270,11 @@ type HealthStatus struct {
 }
 
 type Check struct {
-	Name    string        `json:"name"`
-	Status  string        `json:"status"`
-	Latency time.Duration `json:"latency"`
-	Error   string        `json:"error,omitempty"`
+	Name    string         `json:"name"`
+	Status  string         `json:"status"`
+	Latency time.Duration  `json:"latency"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
 }
 
 // PerformHealthCheck performs comprehensive health checks
@@ -214,6 +303,23 @@ func PerformHealthCheck(ctx context.Context, version string) *HealthStatus {
 		overallStatus = "unhealthy"
 	}
 
+	// Checks registered by other packages
+	healthChecksMu.Lock()
+	registered := healthChecks
+	healthChecksMu.Unlock()
+	for _, run := range registered {
+		start := time.Now()
+		check := run()
+		check.Latency = time.Since(start)
+		checks = append(checks, check)
+		switch {
+		case check.Status == "unhealthy":
+			overallStatus = "unhealthy"
+		case check.Status != "healthy" && overallStatus == "healthy":
+			overallStatus = "degraded"
+		}
+	}
+
 	return &HealthStatus{
 		Status:    overallStatus,
 		Timestamp: time.Now(),
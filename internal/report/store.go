@@ -0,0 +1,286 @@
+package report
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Open when no report exists for a batch ID.
+var ErrNotFound = errors.New("no report for this batch")
+
+// pruneInterval is how often old reports are looked for when batches start.
+const pruneInterval = time.Hour
+
+// batchIDPattern matches the IDs handed out to batches, which keeps report
+// paths inside the store directory.
+var batchIDPattern = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// Store keeps one report per batch in a directory: <id>.ndjson holds the
+// results, one per line in the order they finished, and <id>.summary.json
+// the summary written once the batch is over. Reports older than the
+// retention period are deleted.
+type Store struct {
+	dir       string
+	retention time.Duration
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+// NewStore returns a store writing to dir, which is created when needed.
+func NewStore(dir string, retention time.Duration) *Store {
+	return &Store{dir: dir, retention: retention}
+}
+
+// ValidID reports whether id has the form of a batch ID.
+func ValidID(id string) bool {
+	return batchIDPattern.MatchString(id)
+}
+
+func (s *Store) resultsPath(id string) string {
+	return filepath.Join(s.dir, id+".ndjson")
+}
+
+func (s *Store) summaryPath(id string) string {
+	return filepath.Join(s.dir, id+".summary.json")
+}
+
+// Recorder appends the results of one batch to its report. It is not safe
+// for concurrent use; batches record from the goroutine streaming results.
+// A nil Recorder records nothing.
+type Recorder struct {
+	store  *Store
+	id     string
+	file   *os.File
+	failed bool // Set after the first write error, which is logged once
+}
+
+// Create starts the report of a new batch.
+func (s *Store) Create(id string) (*Recorder, error) {
+	s.prune()
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(s.resultsPath(id), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{store: s, id: id, file: file}, nil
+}
+
+// Add appends one result. Reports are best effort: a failing disk is logged
+// and never stops the batch.
+func (r *Recorder) Add(result *model.EmailResult) {
+	if r == nil || r.failed {
+		return
+	}
+	line, err := json.Marshal(result)
+	if err == nil {
+		_, err = r.file.Write(append(line, '\n'))
+	}
+	r.fail(err)
+}
+
+// Finish writes the summary and closes the report.
+func (r *Recorder) Finish(summary model.BatchSummary) {
+	if r == nil {
+		return
+	}
+	r.fail(r.file.Close())
+	if r.failed {
+		return
+	}
+	data, err := json.Marshal(summary)
+	if err == nil {
+		err = os.WriteFile(r.store.summaryPath(r.id), data, 0o640)
+	}
+	r.fail(err)
+}
+
+func (r *Recorder) fail(err error) {
+	if err != nil && !r.failed {
+		r.failed = true
+		log.Printf("⚠️ Could not record report of batch %s: %v", r.id, err)
+	}
+}
+
+// Report is a stored batch report opened for reading. Close it when done.
+type Report struct {
+	Summary *model.BatchSummary // Nil while the batch is still running
+	results *os.File
+}
+
+// Open returns the report of batch id, or ErrNotFound.
+func (s *Store) Open(id string) (*Report, error) {
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	results, err := os.Open(s.resultsPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{results: results}
+	data, err := os.ReadFile(s.summaryPath(id))
+	if err == nil {
+		var summary model.BatchSummary
+		if json.Unmarshal(data, &summary) == nil {
+			report.Summary = &summary
+		}
+	}
+	return report, nil
+}
+
+// Close releases the report's file.
+func (r *Report) Close() error {
+	return r.results.Close()
+}
+
+// Each calls fn for every recorded result. A line still being written by a
+// running batch is left out.
+func (r *Report) Each(fn func(*model.EmailResult) error) error {
+	reader := bufio.NewReader(r.results)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil // A trailing line without newline is incomplete
+		}
+		if err != nil {
+			return err
+		}
+		var result model.EmailResult
+		if err := json.Unmarshal(line, &result); err != nil {
+			return err
+		}
+		if err := fn(&result); err != nil {
+			return err
+		}
+	}
+}
+
+// WriteJSON writes {"batch_id", "summary", "results": [...]}, streaming the
+// results so large reports are never held in memory.
+func (r *Report) WriteJSON(w io.Writer, id string) error {
+	head, err := json.Marshal(struct {
+		BatchID string              `json:"batch_id"`
+		Summary *model.BatchSummary `json:"summary"`
+	}{id, r.Summary})
+	if err != nil {
+		return err
+	}
+	head = bytes.TrimSuffix(head, []byte("}"))
+	if _, err := io.WriteString(w, string(head)+`,"results":[`); err != nil {
+		return err
+	}
+
+	first := true
+	err = r.Each(func(result *model.EmailResult) error {
+		line, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		if !first {
+			line = append([]byte{','}, line...)
+		}
+		first = false
+		_, err = w.Write(line)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// csvHeader names the columns written by WriteCSV.
+var csvHeader = []string{"index", "email", "status", "smtp_code", "error", "field", "message_id", "started_at", "finished_at"}
+
+// WriteCSV writes one row per result, ready for spreadsheets and for
+// re-sending to the failed recipients.
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	err := r.Each(func(result *model.EmailResult) error {
+		code := ""
+		if result.SMTPCode != 0 {
+			code = strconv.Itoa(result.SMTPCode)
+		}
+		return writer.Write([]string{
+			strconv.Itoa(result.Index),
+			csvCell(result.Email),
+			result.Status,
+			code,
+			csvCell(result.Error),
+			result.Field,
+			csvCell(result.MessageID),
+			formatTime(result.StartedAt),
+			formatTime(result.FinishedAt),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvCell keeps spreadsheet applications from running submitted values as
+// formulas.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// prune deletes reports older than the retention period, at most once per pruneInterval.
+func (s *Store) prune() {
+	s.mu.Lock()
+	if s.retention <= 0 || time.Since(s.lastPruned) < pruneInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPruned = time.Now()
+	s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return // Nothing stored yet
+	}
+	cutoff := time.Now().Add(-s.retention)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".ndjson") && !strings.HasSuffix(name, ".summary.json") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+			_ = os.Remove(filepath.Join(s.dir, name))
+		}
+	}
+}
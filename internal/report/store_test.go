@@ -0,0 +1,124 @@
+package report
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testID = "0123456789abcdef01234567"
+
+func TestReportRoundTrip(t *testing.T) {
+	store := NewStore(t.TempDir(), 0)
+	recorder, err := store.Create(testID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Date(2025, 3, 1, 9, 30, 0, 0, time.UTC)
+	recorder.Add(&model.EmailResult{Index: 1, Email: "b@example.com", Status: "failed", Error: "550 mailbox unavailable", SMTPCode: 550, StartedAt: started, FinishedAt: started.Add(time.Second)})
+	recorder.Add(&model.EmailResult{Index: 0, Email: "=cmd@example.com", Status: "success", SMTPCode: 250, MessageID: "<x@example.com>", StartedAt: started, FinishedAt: started.Add(time.Second)})
+
+	// A running batch has no summary yet
+	running, err := store.Open(testID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running.Summary != nil {
+		t.Error("Expected no summary before Finish")
+	}
+	running.Close()
+
+	recorder.Finish(model.BatchSummary{BatchID: testID, Status: "completed", Total: 2, Sent: 1, Failed: 1})
+
+	finished, err := store.Open(testID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := finished.WriteJSON(&out, testID); err != nil {
+		t.Fatal(err)
+	}
+	finished.Close()
+
+	var decoded struct {
+		BatchID string              `json:"batch_id"`
+		Summary *model.BatchSummary `json:"summary"`
+		Results []model.EmailResult `json:"results"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Invalid JSON report %q: %v", out.String(), err)
+	}
+	if decoded.Summary == nil || decoded.Summary.Failed != 1 || len(decoded.Results) != 2 || decoded.Results[0].SMTPCode != 550 {
+		t.Errorf("Unexpected report %+v", decoded)
+	}
+
+	csvReport, _ := store.Open(testID)
+	defer csvReport.Close()
+	out.Reset()
+	if err := csvReport.WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `index,email,status,smtp_code,error,field,message_id,started_at,finished_at
+1,b@example.com,failed,550,550 mailbox unavailable,,,2025-03-01T09:30:00Z,2025-03-01T09:30:01Z
+0,'=cmd@example.com,success,250,,,<x@example.com>,2025-03-01T09:30:00Z,2025-03-01T09:30:01Z
+`
+	if out.String() != want {
+		t.Errorf("CSV report:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestReportSkipsIncompleteLine(t *testing.T) {
+	store := NewStore(t.TempDir(), 0)
+	recorder, _ := store.Create(testID)
+	recorder.Add(&model.EmailResult{Index: 0, Email: "a@example.com", Status: "success"})
+	recorder.file.WriteString(`{"index":1,"email":"b@exa`) // Caught mid-write
+
+	report, err := store.Open(testID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer report.Close()
+	count := 0
+	if err := report.Each(func(*model.EmailResult) error { count++; return nil }); err != nil || count != 1 {
+		t.Errorf("Each() = %v after %d results, want 1 result", err, count)
+	}
+}
+
+func TestReportOpenRejectsUnknownIDs(t *testing.T) {
+	store := NewStore(t.TempDir(), 0)
+	for _, id := range []string{testID, "../../etc/passwd", "ABCDEF"} {
+		if _, err := store.Open(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Open(%q) = %v, want ErrNotFound", id, err)
+		}
+	}
+}
+
+func TestReportPrune(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir, time.Hour)
+	old, _ := store.Create(testID)
+	old.Finish(model.BatchSummary{BatchID: testID})
+	past := time.Now().Add(-2 * time.Hour)
+	for _, path := range []string{store.resultsPath(testID), store.summaryPath(testID)} {
+		if err := os.Chtimes(path, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store.lastPruned = time.Time{} // Due for the next sweep
+	fresh, err := store.Create(strings.Repeat("a", 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh.Finish(model.BatchSummary{})
+
+	if _, err := store.Open(testID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the old report to be pruned, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/model"
+	"Form-Mailly-Go/internal/template"
+	"Form-Mailly-Go/internal/validation"
+	"cmp"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrAutoReplyLimited is returned when an address has received as many
+// auto-replies as AUTO_REPLY_LIMIT allows within AUTO_REPLY_WINDOW.
+var ErrAutoReplyLimited = errors.New("auto-reply limit reached for this address")
+
+// replyLimiter counts the auto-replies sent to each address, so the contact
+// form cannot be used to flood somebody else's inbox with confirmations.
+type replyLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	now       func() time.Time
+	sent      map[string][]time.Time // Send times within the window, oldest first
+	lastSweep time.Time
+}
+
+var (
+	replies     *replyLimiter
+	repliesOnce sync.Once
+)
+
+// autoReplies returns the process-wide limiter, created from the configuration on first use.
+func autoReplies() *replyLimiter {
+	repliesOnce.Do(func() {
+		replies = newReplyLimiter(config.EnvVar.AutoReplyLimit, config.EnvVar.AutoReplyWindow)
+	})
+	return replies
+}
+
+func newReplyLimiter(limit int, window time.Duration) *replyLimiter {
+	return &replyLimiter{limit: limit, window: window, now: time.Now, sent: make(map[string][]time.Time)}
+}
+
+// allow counts one auto-reply to address, unless it has had limit of them within the window.
+func (l *replyLimiter) allow(address string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= l.window {
+		// Forget addresses that have not been written to for a whole window
+		for key, times := range l.sent {
+			if now.Sub(times[len(times)-1]) >= l.window {
+				delete(l.sent, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	// Aliases of one mailbox, such as Gmail dots and +tags, share a limit
+	key := validation.DedupeKey(address, true)
+	times := l.sent[key]
+	for len(times) > 0 && now.Sub(times[0]) >= l.window {
+		times = times[1:]
+	}
+	if len(times) >= l.limit {
+		l.sent[key] = times
+		return false
+	}
+	l.sent[key] = append(times, now)
+	return true
+}
+
+// SendAutoReply confirms a submission to the address it came from, with a
+// copy of the message, when its form has an auto-reply configured in
+// FORMS_FILE. Replies to the confirmation go to the form's reply_to, or to
+// RECEIVER_EMAIL. Each address gets at most AUTO_REPLY_LIMIT confirmations
+// per AUTO_REPLY_WINDOW, counted whether or not they are delivered; past
+// that it returns ErrAutoReplyLimited without sending.
+func SendAutoReply(ctx context.Context, form *model.ContactForm) error {
+	settings := config.EnvVar.Form(form.ProductName).AutoReply
+	if settings == nil {
+		return nil
+	}
+	if err := CheckSMTPAvailable(); err != nil {
+		return err
+	}
+	if !autoReplies().allow(form.Email) {
+		return ErrAutoReplyLimited
+	}
+
+	name := cmp.Or(settings.Template, template.DefaultAutoReplyTemplate)
+	locale := ContactLocale(form)
+	message, err := template.Render(name, locale, template.NewContactData(form, locale, contactTime(form)))
+	if err != nil {
+		return err
+	}
+	subject := cmp.Or(message.Subject, locale.Labels["auto_reply_subject"])
+	replyTo := cmp.Or(settings.ReplyTo, config.EnvVar.ReceiverEmail)
+	return deliver(ctx, form.ProductName, form.Email, replyTo, subject, message)
+}
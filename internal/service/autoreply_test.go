@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplyLimiterPerAddress(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newReplyLimiter(2, time.Hour)
+	l.now = func() time.Time { return now }
+
+	if !l.allow("ada@example.com") || !l.allow(" ADA@example.com") {
+		t.Fatal("Expected the first two replies to be allowed")
+	}
+	if l.allow("Ada@Example.com") {
+		t.Error("Expected the third reply within the window to be refused, whatever the case")
+	}
+	if !l.allow("bob@example.com") {
+		t.Error("Other addresses have their own limit")
+	}
+	if !l.allow("grace.hopper@gmail.com") || !l.allow("gracehopper+news@googlemail.com") {
+		t.Fatal("Expected the first two replies to be allowed")
+	}
+	if l.allow("Grace.Hopper+promo@gmail.com") {
+		t.Error("Expected aliases of one mailbox to share its limit")
+	}
+
+	now = now.Add(time.Hour)
+	if !l.allow("ada@example.com") {
+		t.Error("Expected replies to be allowed again after the window")
+	}
+	if len(l.sent) != 1 {
+		t.Errorf("Expected addresses idle for a window to be forgotten, got %d", len(l.sent))
+	}
+}
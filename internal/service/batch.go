@@ -3,14 +3,32 @@ package service
 import (
 	"Form-Mailly-Go/internal/config"
 	"Form-Mailly-Go/internal/model"
+	"Form-Mailly-Go/internal/template"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/smtp"
+	"net/textproto"
 	"strings"
 )
 
+// SetupNewSMTPConnection opens an authenticated connection for a batch
+// worker. While the circuit breaker is open it fails fast with a
+// *CircuitOpenError instead of trying.
 func SetupNewSMTPConnection() (*smtp.Client, error) {
+	b := smtpBreaker()
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	client, err := connectSMTP()
+	b.record(err)
+	return client, err
+}
+
+func connectSMTP() (*smtp.Client, error) {
 	addr := config.EnvVar.SMTPHost + ":" + config.EnvVar.SMTPPort
 
 	//d := &net.Dialer{Timeout: 15 * time.Second, KeepAlive: 30 * time.Second}
@@ -18,13 +36,13 @@ func SetupNewSMTPConnection() (*smtp.Client, error) {
 	// 1️⃣ TCP connect
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SMTP: %v", err)
+		return nil, fmt.Errorf("failed to connect to SMTP: %w", err)
 	}
 
 	// 2️⃣ Create SMTP client
 	client, err := smtp.NewClient(conn, config.EnvVar.SMTPHost)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create SMTP client: %v", err)
+		return nil, fmt.Errorf("failed to create SMTP client: %w", err)
 	}
 
 	// 3️⃣ STARTTLS upgrade
@@ -34,62 +52,86 @@ func SetupNewSMTPConnection() (*smtp.Client, error) {
 			MinVersion: tls.VersionTLS12, // consider TLS13 if your SMTP server supports it
 		}
 		if err = client.StartTLS(tlsConfig); err != nil {
-			return nil, fmt.Errorf("failed to start TLS: %v", err)
+			client.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
 		}
 	} else {
+		client.Close()
 		return nil, fmt.Errorf("SMTP server does not support STARTTLS")
 	}
 
 	// 4️⃣ Authenticate
 	auth := smtp.PlainAuth("", config.EnvVar.SenderEmail, config.EnvVar.SenderPassword, config.EnvVar.SMTPHost)
 	if err = client.Auth(auth); err != nil {
-		return nil, fmt.Errorf("failed to authenticate: %v", err)
+		client.Close()
+		return nil, fmt.Errorf("failed to authenticate: %w", err)
 	}
 	return client, nil
 }
 
-func SendEmailUsingWorker(client *smtp.Client, email *model.Email) error {
+// SendEmailUsingWorker sends one email over an open connection and returns
+// the Message-ID it was sent with. Callers take the send from the quota with
+// ReserveSend first.
+func SendEmailUsingWorker(client *smtp.Client, email *model.Email) (string, error) {
 	if client == nil {
-		return fmt.Errorf("client is nil")
+		return "", fmt.Errorf("client is nil")
 	}
 
 	// Sets up the recipient list.
 	to := email.SentTo
+	messageID := newMessageID()
 
 	// Sets the sender service address in the SMTP protocol using MAIL FROM:<sender>.
 	if err := client.Mail(config.EnvVar.SenderEmail); err != nil { // Starts new service (MAIL FROM)
-		return err
+		return "", err
 	}
 
 	// Adds the recipient to the envelope using RCPT TO:<recipient>.
 	if err := client.Rcpt(to); err != nil { // Adds recipient (RCPT TO)
-		return err
+		return "", err
 	}
 
 	// Opens the data stream to start sending the service body.
 	writer, err := client.Data() // Prepares to send service content
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	// Composes the service message with headers and the body.
-	msg := []byte(
-		"From: " + sanitize(email.ProductName) + " <" + config.EnvVar.SenderEmail + ">\r\n" +
-			"To: " + to + "\r\n" +
-			"Subject: " + sanitize(email.Subject) + "\r\n" +
-			"MIME-Version: 1.0\r\n" +
-			"Content-Type: text/html; charset=\"UTF-8\"\r\n" +
-			"\r\n" +
-			email.Message,
-	)
+	// Composes the service message with headers and the body, with a plain-text
+	// version of the HTML for text-only clients and spam filters.
+	text := email.Text
+	if text == "" {
+		text = template.HTMLToText(email.Message)
+	}
+	msg := composeMessage(email.ProductName, to, "", messageID, email.Subject, &template.Message{HTML: email.Message, Text: text})
 
 	// Writes the message content to the SMTP data stream.
 	if _, err = writer.Write(msg); err != nil { // Sends the body
-		return err
+		return "", err
 	}
 
 	// It tells the SMTP server that the message is complete. Without this, the service won't be sent. If you don’t close the writer, the SMTP server won’t process or deliver the message.
-	return writer.Close() // Ends the service
+	if err := writer.Close(); err != nil { // Ends the service
+		return "", err
+	}
+	return messageID, nil
+}
+
+// newMessageID returns a unique Message-ID in the sender's domain.
+func newMessageID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	sender := config.EnvVar.SenderEmail
+	return "<" + hex.EncodeToString(b) + "@" + sender[strings.LastIndexByte(sender, '@')+1:] + ">"
+}
+
+// SMTPCode returns the SMTP reply code carried by err, or 0 if the server never answered.
+func SMTPCode(err error) int {
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code
+	}
+	return 0
 }
 
 // CloseSMTPConnection Close the connection when done
@@ -99,6 +141,13 @@ func CloseSMTPConnection(client *smtp.Client) {
 	}
 }
 
+// IsThrottled reports whether err is an SMTP reply asking the client to slow
+// down: 421 (service closing the channel) or 451 (local error, try later).
+func IsThrottled(err error) bool {
+	code := SMTPCode(err)
+	return code == 421 || code == 451
+}
+
 func sanitize(s string) string {
 	s = strings.ReplaceAll(s, "\r", "")
 	s = strings.ReplaceAll(s, "\n", "")
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/monitoring"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// ErrSMTPUnavailable matches every *CircuitOpenError, for callers that only
+// need to know the SMTP server is considered down.
+var ErrSMTPUnavailable = errors.New("SMTP server is unavailable")
+
+// CircuitOpenError reports that sends are refused without trying, because the
+// last connections to the SMTP server failed, and when a new attempt is made.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+	Cause      string // Last connection failure
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("SMTP server is unavailable (%s), retry in %v", e.Cause, e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrSMTPUnavailable
+}
+
+// Circuit breaker states, as reported by /api/health.
+const (
+	breakerClosed   = "closed"    // Connections are attempted normally
+	breakerOpen     = "open"      // Sends fail fast until the cooldown has passed
+	breakerHalfOpen = "half_open" // One probe connection decides whether to close again
+)
+
+// circuitBreaker stops connecting to an SMTP server that keeps failing. After
+// threshold consecutive connection or authentication failures it opens and
+// refuses sends for cooldown; then a single probe is let through, which closes
+// the circuit on success and opens it for another cooldown on failure.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	state    string
+	failures int       // Consecutive connection failures
+	openedAt time.Time // When the circuit opened or the last probe started
+	lastErr  string
+}
+
+var (
+	breaker     *circuitBreaker
+	breakerOnce sync.Once
+)
+
+func init() {
+	monitoring.RegisterHealthCheck(func() monitoring.Check {
+		return smtpBreaker().check()
+	})
+}
+
+// smtpBreaker returns the process-wide breaker, created from the configuration on first use.
+func smtpBreaker() *circuitBreaker {
+	breakerOnce.Do(func() {
+		breaker = newCircuitBreaker(config.EnvVar.SMTPBreakerThreshold, config.EnvVar.SMTPBreakerCooldown)
+	})
+	return breaker
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now, state: breakerClosed}
+}
+
+// CheckSMTPAvailable returns a *CircuitOpenError while the SMTP server is
+// considered down, without using up the probe. Senders call it before waiting
+// for quota or a connection slot, so they fail fast instead.
+func CheckSMTPAvailable() error {
+	b := smtpBreaker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.refusedLocked(b.now())
+}
+
+// allow reports whether a connection may be attempted now. Once the cooldown
+// has passed it lets one caller through as the probe.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if err := b.refusedLocked(now); err != nil {
+		return err
+	}
+	if b.state != breakerClosed {
+		// Others wait another cooldown unless the probe succeeds, and a probe
+		// that never reports back is replaced after that time
+		b.state, b.openedAt = breakerHalfOpen, now
+	}
+	return nil
+}
+
+// refusedLocked returns the error for callers refused at now. Callers must hold b.mu.
+func (b *circuitBreaker) refusedLocked(now time.Time) error {
+	if b.state == breakerClosed {
+		return nil
+	}
+	if wait := b.cooldown - now.Sub(b.openedAt); wait > 0 {
+		return &CircuitOpenError{RetryAfter: wait, Cause: b.lastErr}
+	}
+	return nil
+}
+
+// record counts the outcome of a connection attempt let through by allow.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !connectionFailure(err) {
+		if b.state != breakerClosed {
+			fmt.Println("SMTP circuit closed: the server is reachable again")
+		}
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+
+	b.failures++
+	b.lastErr = err.Error()
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state == breakerClosed {
+			fmt.Printf("SMTP circuit opened after %d consecutive failures: %v\n", b.failures, err)
+		}
+		b.state, b.openedAt = breakerOpen, b.now()
+	}
+}
+
+// check reports the breaker for /api/health: degraded while sends are refused.
+func (b *circuitBreaker) check() monitoring.Check {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	check := monitoring.Check{
+		Name:    "smtp_circuit",
+		Status:  "healthy",
+		Details: map[string]any{"state": b.state, "consecutive_failures": b.failures},
+	}
+	if b.state != breakerClosed {
+		check.Status = "degraded"
+		check.Error = "SMTP server unreachable: " + b.lastErr
+		if wait := b.cooldown - b.now().Sub(b.openedAt); wait > 0 {
+			check.Details["retry_in_seconds"] = int(wait.Round(time.Second).Seconds())
+		}
+	}
+	return check
+}
+
+// connectionFailure reports whether err means the SMTP server could not be
+// used at all: network and TLS errors, and replies refusing the session or
+// the login. Replies about a single message, such as an unknown recipient,
+// show the server is up.
+func connectionFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		switch reply.Code {
+		case 421, 454, 530, 534, 535:
+			return true
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"errors"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndProbes(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(3, 30*time.Second)
+	b.now = func() time.Time { return now }
+	refused := errors.New("connection refused")
+
+	// Replies about a message show the server is up and reset the count
+	b.record(refused)
+	b.record(&textproto.Error{Code: 550, Msg: "no such user"})
+	for range 2 {
+		b.record(refused)
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected the circuit to stay closed below the threshold, got %v", err)
+	}
+	b.record(&textproto.Error{Code: 535, Msg: "authentication failed"})
+
+	var openErr *CircuitOpenError
+	if err := b.allow(); !errors.As(err, &openErr) || !errors.Is(err, ErrSMTPUnavailable) || openErr.RetryAfter != 30*time.Second {
+		t.Fatalf("Expected the circuit to open after 3 failures, got %v", err)
+	}
+	if check := b.check(); check.Status != "degraded" || check.Details["state"] != breakerOpen {
+		t.Errorf("Unexpected health check %+v", check)
+	}
+
+	// After the cooldown one probe goes through; a failing probe reopens the circuit
+	now = now.Add(30 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected a probe after the cooldown, got %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrSMTPUnavailable) {
+		t.Errorf("Expected a single probe at a time, got %v", err)
+	}
+	b.record(refused)
+	if err := b.allow(); !errors.Is(err, ErrSMTPUnavailable) {
+		t.Errorf("Expected a failed probe to reopen the circuit, got %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatal(err)
+	}
+	b.record(nil)
+	if err := b.allow(); err != nil || b.check().Status != "healthy" {
+		t.Errorf("Expected a successful probe to close the circuit, got %v", err)
+	}
+}
@@ -4,28 +4,123 @@ import (
 	"Form-Mailly-Go/internal/config"
 	"Form-Mailly-Go/internal/model"
 	"Form-Mailly-Go/internal/template"
+	"context"
+	"log"
 	"net/smtp"
+	"time"
 )
 
-func Send(form *model.ContactForm) error {
+// Send delivers a contact form submission to the configured receiver. It
+// waits for one of the shared SMTP connection slots and then takes one send
+// from the quota, returning ErrSMTPSaturated or a *QuotaError when either
+// cannot be had in time. Contact mail is transactional, so it is served
+// ahead of any batch waiting for the same quota or connections. While the SMTP
+// server is considered down it fails fast with a *CircuitOpenError.
+func Send(ctx context.Context, form *model.ContactForm) error {
+	if err := CheckSMTPAvailable(); err != nil {
+		return err
+	}
+	locale := ContactLocale(form)
+	message, err := template.Render(ContactTemplate(form), locale, template.NewContactData(form, locale, contactTime(form)))
+	if err != nil {
+		return err
+	}
+	subject := form.Subject
+	if message.Subject != "" {
+		subject = message.Subject
+	}
+	return deliver(ctx, form.ProductName, config.EnvVar.ReceiverEmail, "", subject, message)
+}
+
+// deliver sends one rendered transactional email to a single address, taking
+// a shared SMTP connection slot and then the send from the quota first. replyTo
+// sets a Reply-To header when not empty.
+func deliver(ctx context.Context, fromName, to, replyTo, subject string, message *template.Message) error {
+	msg := composeMessage(fromName, to, replyTo, "", subject, message)
+
+	slot, err := AcquireSMTPSlot(ctx, ContactTenant, PriorityTransactional)
+	if err != nil {
+		return err
+	}
+	defer slot.Release()
 
 	auth := smtp.PlainAuth("", config.EnvVar.SenderEmail, config.EnvVar.SenderPassword, config.EnvVar.SMTPHost)
-	to := []string{config.EnvVar.ReceiverEmail}
-	msg := []byte(
-		"From: " + form.ProductName + " <" + config.EnvVar.SenderEmail + ">\r\n" +
-			"To: " + to[0] + "\r\n" +
-			"Subject: " + form.Subject + "\r\n" +
-			"Content-Type: text/html; charset=\"UTF-8\"\r\n" +
-			"MIME-Version: 1.0\r\n" +
-			"\r\n" +
-			template.BuildContactFormMessage2(form),
-	)
 
-	return smtp.SendMail(
+	b := smtpBreaker()
+	if err := b.allow(); err != nil {
+		return err // Another sender's probe is deciding whether the server is back
+	}
+	// The quota is only used once the send can go ahead
+	if err := ReserveSend(ctx, PriorityTransactional); err != nil {
+		return err
+	}
+	err = smtp.SendMail(
 		config.EnvVar.SMTPHost+":"+config.EnvVar.SMTPPort,
 		auth,
 		config.EnvVar.SenderEmail,
-		to,
+		[]string{to},
 		msg,
 	)
+	b.record(err)
+	return err
+}
+
+// ContactTemplate returns the template a submission is rendered with: the one
+// it names, else its form's default from FORMS_FILE, else CONTACT_TEMPLATE.
+func ContactTemplate(form *model.ContactForm) string {
+	for _, name := range []string{form.Template, config.EnvVar.Form(form.ProductName).Template, config.EnvVar.ContactTemplate} {
+		if name != "" {
+			return name
+		}
+	}
+	return template.DefaultContactTemplate
+}
+
+// ContactLocale resolves the locale a submission is rendered in: its locale
+// field, then the languages of its Accept-Language header, then its form's
+// locale from FORMS_FILE, then DEFAULT_LOCALE, each falling back to English.
+func ContactLocale(form *model.ContactForm) *template.Locale {
+	preferences := append([]string{form.Locale}, template.ParseAcceptLanguage(form.AcceptLanguage)...)
+	preferences = append(preferences, config.EnvVar.Form(form.ProductName).Locale, config.EnvVar.DefaultLocale)
+	return template.ResolveLocale(preferences...)
+}
+
+// contactTime returns when a submission was received, in the timezone of its
+// form, so the notification and the auto-reply show the same time.
+func contactTime(form *model.ContactForm) time.Time {
+	at := form.ReceivedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return at.In(config.EnvVar.Location(form.ProductName))
+}
+
+// CheckTemplateSettings logs every configured template that does not exist,
+// and every configured locale that is not a locale tag, so a typo in
+// CONTACT_TEMPLATE, DEFAULT_LOCALE or FORMS_FILE shows up at startup rather than
+// as failed contact mail.
+func CheckTemplateSettings() {
+	if name := config.EnvVar.ContactTemplate; name != "" {
+		if err := template.Check(name); err != nil {
+			log.Printf("⚠️ CONTACT_TEMPLATE: %v", err)
+		}
+	}
+	if err := template.CheckLocale(config.EnvVar.DefaultLocale); err != nil {
+		log.Printf("⚠️ DEFAULT_LOCALE: %v", err)
+	}
+	for product, form := range config.EnvVar.Forms {
+		if form.Template != "" {
+			if err := template.Check(form.Template); err != nil {
+				log.Printf("⚠️ Form %q: %v", product, err)
+			}
+		}
+		if err := template.CheckLocale(form.Locale); err != nil {
+			log.Printf("⚠️ Form %q: %v", product, err)
+		}
+		if form.AutoReply != nil && form.AutoReply.Template != "" {
+			if err := template.Check(form.AutoReply.Template); err != nil {
+				log.Printf("⚠️ Form %q auto-reply: %v", product, err)
+			}
+		}
+	}
 }
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/template"
+	"bytes"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+)
+
+// composeMessage returns an email as it goes over SMTP: its headers, from the
+// configured sender under fromName, and its body. replyTo and messageID add a
+// Reply-To and a Message-ID header when not empty. Line breaks are removed
+// from every value, as they may come from a visitor or a batch entry and would
+// start new headers.
+func composeMessage(fromName, to, replyTo, messageID, subject string, message *template.Message) []byte {
+	contentType, body := messageBody(message)
+	header := "From: " + sanitize(fromName) + " <" + config.EnvVar.SenderEmail + ">\r\n" +
+		"To: " + sanitize(to) + "\r\n"
+	if replyTo != "" {
+		header += "Reply-To: " + sanitize(replyTo) + "\r\n"
+	}
+	header += "Subject: " + sanitize(subject) + "\r\n"
+	if messageID != "" {
+		header += "Message-ID: " + sanitize(messageID) + "\r\n"
+	}
+	return append([]byte(header+
+		"Content-Type: "+contentType+"\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"\r\n"),
+		body...,
+	)
+}
+
+// messageBody returns the Content-Type header and body of a rendered email: a
+// multipart/alternative with the plain-text version first, so mail clients
+// pick the richest part they can show, or the HTML alone when it has no text.
+func messageBody(message *template.Message) (contentType string, body []byte) {
+	if message.Text == "" {
+		return `text/html; charset="UTF-8"`, []byte(message.HTML)
+	}
+
+	var buf bytes.Buffer
+	parts := multipart.NewWriter(&buf)
+	writePart(parts, "text/plain", message.Text)
+	writePart(parts, "text/html", message.HTML)
+	_ = parts.Close()
+	return "multipart/alternative; boundary=" + parts.Boundary(), buf.Bytes()
+}
+
+// writePart adds a quoted-printable part, which keeps lines within the SMTP limit.
+func writePart(parts *multipart.Writer, mediaType, content string) {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mediaType+`; charset="UTF-8"`)
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	part, _ := parts.CreatePart(header) // Writes to a bytes.Buffer cannot fail
+
+	encoder := quotedprintable.NewWriter(part)
+	_, _ = encoder.Write([]byte(content))
+	_ = encoder.Close()
+}
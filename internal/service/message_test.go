@@ -0,0 +1,35 @@
+package service
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/template"
+	"strings"
+	"testing"
+)
+
+func TestComposeMessageStripsLineBreaks(t *testing.T) {
+	previous := config.EnvVar
+	config.EnvVar = &config.EnvironmentVariable{SenderEmail: "noreply@example.com"}
+	t.Cleanup(func() { config.EnvVar = previous })
+
+	msg := string(composeMessage(
+		"Shop\r\nBcc: victim@example.com",
+		"owner@example.com\n",
+		"ada@example.com\r\nX-Injected: 1",
+		"<id@example.com>\r\nX-Injected: 1",
+		"Hi\r\nBcc: victim@example.com",
+		&template.Message{HTML: "<p>Hello</p>"},
+	))
+	header, _, _ := strings.Cut(msg, "\r\n\r\n")
+	for _, line := range strings.Split(header, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") || strings.HasPrefix(line, "X-Injected:") {
+			t.Errorf("Header injected through a value: %q", line)
+		}
+	}
+	if !strings.Contains(header, "Subject: HiBcc: victim@example.com\r\n") {
+		t.Errorf("Expected the subject on one line, got:\n%s", header)
+	}
+	if !strings.Contains(header, "Message-ID: <id@example.com>X-Injected: 1\r\n") {
+		t.Errorf("Expected the Message-ID on one line, got:\n%s", header)
+	}
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/model"
+	"Form-Mailly-Go/internal/template"
+)
+
+// PreviewTemplate renders the named template with form as contact mail would
+// be right now, in the same locale and timezone, without sending anything.
+// It returns the rendered message and the raw MIME message it would go out
+// as to RECEIVER_EMAIL.
+func PreviewTemplate(name string, form *model.ContactForm) (*template.Message, []byte, error) {
+	locale := ContactLocale(form)
+	message, err := template.Render(name, locale, template.NewContactData(form, locale, contactTime(form)))
+	if err != nil {
+		return nil, nil, err
+	}
+	subject := form.Subject
+	if message.Subject != "" {
+		subject = message.Subject
+	}
+	return message, composeMessage(form.ProductName, config.EnvVar.ReceiverEmail, "", "", subject, message), nil
+}
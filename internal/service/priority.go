@@ -0,0 +1,54 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// Priority orders senders competing for SMTP connections and send quota.
+// Contact form mail is transactional; batches are bulk unless they ask for normal.
+type Priority int
+
+const (
+	PriorityBulk Priority = iota
+	PriorityNormal
+	PriorityTransactional
+)
+
+// priorityAging is how long a waiter waits before it is served as the next
+// higher class, so bulk traffic still moves under a steady stream of
+// transactional mail. Aging stops below transactional, which always goes first.
+const priorityAging = 2 * time.Second
+
+// ParsePriority reads "bulk", "normal" or "transactional".
+func ParsePriority(name string) (Priority, error) {
+	switch name {
+	case "bulk":
+		return PriorityBulk, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "transactional":
+		return PriorityTransactional, nil
+	}
+	return 0, fmt.Errorf("unknown priority %q, use bulk, normal or transactional", name)
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityBulk:
+		return "bulk"
+	case PriorityNormal:
+		return "normal"
+	case PriorityTransactional:
+		return "transactional"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// aged returns the class a request of priority p is served as after waiting.
+func (p Priority) aged(waited time.Duration) Priority {
+	if p >= PriorityTransactional {
+		return p
+	}
+	return min(PriorityTransactional-1, p+Priority(waited/priorityAging))
+}
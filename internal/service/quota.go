@@ -0,0 +1,358 @@
+package service
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/monitoring"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrSendQuotaExceeded matches every *QuotaError, for callers that only need
+// to know the send was refused by the quota.
+var ErrSendQuotaExceeded = errors.New("send quota exceeded")
+
+// quotaSaveInterval bounds how often usage is written to SEND_QUOTA_FILE.
+const quotaSaveInterval = time.Second
+
+// bulkQuotaReserve is the share of every window bulk batches leave untouched,
+// so contact mail can still go out once a large batch has used the rest.
+const bulkQuotaReserve = 0.05
+
+// QuotaError reports which quota window ran out and when a send fits again.
+type QuotaError struct {
+	Window     string // "second", "minute" or "day"
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("per-%s send quota exhausted, retry in %v", e.Window, e.RetryAfter.Round(time.Second))
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrSendQuotaExceeded
+}
+
+// quotaWindow is one configured limit: at most limit sends in any period.
+type quotaWindow struct {
+	name   string
+	limit  int
+	period time.Duration
+}
+
+// sendLog holds the times of a profile's sends, oldest first. It keeps those
+// within the longest window, so each window counts exactly the sends made in
+// the period before now.
+type sendLog []time.Time
+
+// since returns the sends made after start.
+func (s sendLog) since(start time.Time) sendLog {
+	i, _ := slices.BinarySearchFunc(s, start, func(sent, start time.Time) int {
+		if sent.After(start) {
+			return 1
+		}
+		return -1
+	})
+	return s[i:]
+}
+
+// wait returns how long until w allows need more sends, given the sends of
+// its period. When need is more than the window ever allows, it waits for the
+// whole period.
+func (s sendLog) wait(w quotaWindow, need float64, now time.Time) time.Duration {
+	sent := s.since(now.Add(-w.period))
+	allowed := int(math.Floor(float64(w.limit) - need)) // Sends that may already be in the window
+	switch {
+	case len(sent) <= allowed:
+		return 0
+	case allowed < 0:
+		return w.period
+	}
+	return sent[len(sent)-allowed-1].Add(w.period).Sub(now) // Until enough of them leave the window
+}
+
+// sendLimiter enforces the outbound quota of each sender profile, keyed by the
+// sending account, across contact and batch sends. Usage is saved to a file so
+// a restart does not hand out a fresh daily quota.
+type sendLimiter struct {
+	mu       sync.Mutex
+	windows  []quotaWindow
+	profiles map[string]sendLog // Sends per profile within the longest window
+	maxWait  time.Duration      // Longest a sender waits for the quota before giving up
+	file     string
+	now      func() time.Time
+	waiting  [PriorityTransactional + 1]int // Senders waiting for the quota, per class
+
+	savedAt     time.Time
+	savePending bool // A trailing save is scheduled
+	saveFailed  bool // Logged once until a save succeeds again
+}
+
+var (
+	limiter     *sendLimiter
+	limiterOnce sync.Once
+)
+
+func init() {
+	monitoring.SetQuotaProvider(func() map[string]monitoring.SendQuota {
+		return quota().remaining(senderProfile())
+	})
+}
+
+// quota returns the process-wide limiter, created from the configuration on first use.
+func quota() *sendLimiter {
+	limiterOnce.Do(func() {
+		limiter = newSendLimiter([]quotaWindow{
+			{name: "second", limit: config.EnvVar.SendRatePerSecond, period: time.Second},
+			{name: "minute", limit: config.EnvVar.SendRatePerMinute, period: time.Minute},
+			{name: "day", limit: config.EnvVar.SendRatePerDay, period: 24 * time.Hour},
+		}, config.EnvVar.SMTPQueueTimeout, config.EnvVar.SendQuotaFile)
+	})
+	return limiter
+}
+
+// newSendLimiter keeps the windows that have a limit and loads the usage saved in file, if any.
+func newSendLimiter(windows []quotaWindow, maxWait time.Duration, file string) *sendLimiter {
+	l := &sendLimiter{
+		profiles: make(map[string]sendLog),
+		maxWait:  maxWait,
+		file:     file,
+		now:      time.Now,
+	}
+	for _, w := range windows {
+		if w.limit > 0 {
+			l.windows = append(l.windows, w)
+		}
+	}
+	l.load()
+	return l
+}
+
+// senderProfile names the account every send currently goes out from.
+func senderProfile() string {
+	return config.EnvVar.SenderEmail
+}
+
+// ReserveSend takes one send from the sender's quota, waiting up to
+// SMTP_QUEUE_TIMEOUT for every window to allow it. Senders of a higher priority waiting at the
+// same time get the next sends first, and bulk sends leave a small share of
+// every window unused. It returns a *QuotaError when the quota does not allow
+// a send within that time, such as after the daily limit. Every path that
+// hands a message to the SMTP server calls it first.
+func ReserveSend(ctx context.Context, priority Priority) error {
+	return quota().reserve(ctx, senderProfile(), priority)
+}
+
+// CheckSendQuota reports a *QuotaError if the sender's quota could not allow
+// a send of this priority within SMTP_QUEUE_TIMEOUT, without using any of it.
+// Batches call it before accepting work that could never be sent.
+func CheckSendQuota(priority Priority) error {
+	return quota().check(senderProfile(), priority)
+}
+
+// FlushSendQuota writes pending quota usage to SEND_QUOTA_FILE, for shutdown.
+func FlushSendQuota() {
+	l := quota()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.saveLocked()
+}
+
+func (l *sendLimiter) reserve(ctx context.Context, profile string, priority Priority) error {
+	if len(l.windows) == 0 {
+		return nil
+	}
+	deadline := l.now().Add(l.maxWait)
+
+	l.mu.Lock()
+	l.waiting[priority]++
+	defer func() {
+		l.mu.Lock()
+		l.waiting[priority]--
+		l.mu.Unlock()
+	}()
+	l.mu.Unlock()
+
+	for {
+		l.mu.Lock()
+		now := l.now()
+		wait, window := l.waitLocked(profile, priority, now)
+		if wait == 0 {
+			l.profiles[profile] = append(l.profiles[profile], now)
+			l.scheduleSaveLocked(now)
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if now.Add(wait).After(deadline) {
+			return &QuotaError{Window: window, RetryAfter: wait}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *sendLimiter) check(profile string, priority Priority) error {
+	if len(l.windows) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if wait, window := l.waitLocked(profile, priority, l.now()); wait > l.maxWait {
+		return &QuotaError{Window: window, RetryAfter: wait}
+	}
+	return nil
+}
+
+// waitLocked returns how long until every window allows a send of this
+// priority for the profile, with the window that takes longest. A send needs
+// room for itself, for each waiting sender of a higher class and for the
+// reserve bulk sends leave. Callers must hold l.mu.
+func (l *sendLimiter) waitLocked(profile string, priority Priority, now time.Time) (time.Duration, string) {
+	ahead := 0
+	for class := priority + 1; class <= PriorityTransactional; class++ {
+		ahead += l.waiting[class]
+	}
+
+	sent := l.logLocked(profile, now)
+	var longest time.Duration
+	var window string
+	for _, w := range l.windows {
+		need := 1 + float64(ahead)
+		if priority == PriorityBulk {
+			need += bulkQuotaReserve * float64(w.limit)
+		}
+		if wait := sent.wait(w, need, now); wait > longest {
+			longest, window = wait, w.name
+		}
+	}
+	return longest, window
+}
+
+// logLocked returns the profile's sends, after forgetting those older than
+// the longest window. Callers must hold l.mu.
+func (l *sendLimiter) logLocked(profile string, now time.Time) sendLog {
+	var longest time.Duration
+	for _, w := range l.windows {
+		longest = max(longest, w.period)
+	}
+	sent := l.profiles[profile].since(now.Add(-longest))
+	l.profiles[profile] = sent
+	return sent
+}
+
+// remaining reports the whole sends left in each window, always including profile.
+func (l *sendLimiter) remaining(profile string) map[string]monitoring.SendQuota {
+	if len(l.windows) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.logLocked(profile, now)
+	report := make(map[string]monitoring.SendQuota, len(l.profiles))
+	for name := range l.profiles {
+		sent := l.logLocked(name, now)
+		var q monitoring.SendQuota
+		for _, w := range l.windows {
+			left := max(0, w.limit-len(sent.since(now.Add(-w.period))))
+			switch w.name {
+			case "second":
+				q.Second = &left
+			case "minute":
+				q.Minute = &left
+			case "day":
+				q.Day = &left
+			}
+		}
+		report[name] = q
+	}
+	return report
+}
+
+// scheduleSaveLocked saves right away if the last save is old enough, and
+// otherwise once the interval has passed. Callers must hold l.mu.
+func (l *sendLimiter) scheduleSaveLocked(now time.Time) {
+	if l.file == "" || l.savePending {
+		return
+	}
+	if wait := quotaSaveInterval - now.Sub(l.savedAt); wait > 0 {
+		l.savePending = true
+		time.AfterFunc(wait, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.saveLocked()
+		})
+		return
+	}
+	l.saveLocked()
+}
+
+// saveLocked writes every profile's sends to the quota file, replacing it
+// atomically so a crash never leaves half a file. Callers must hold l.mu.
+func (l *sendLimiter) saveLocked() {
+	l.savePending = false
+	if l.file == "" {
+		return
+	}
+	l.savedAt = l.now()
+
+	err := func() error {
+		data, err := json.Marshal(l.profiles)
+		if err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(l.file), filepath.Base(l.file)+".*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name()) // No-op once renamed
+		if _, err := tmp.Write(data); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), l.file)
+	}()
+
+	if err != nil && !l.saveFailed {
+		log.Printf("⚠️ Could not save send quota to %s: %v", l.file, err)
+	}
+	l.saveFailed = err != nil
+}
+
+// load restores the usage saved by a previous run. A missing file means a fresh start.
+func (l *sendLimiter) load() {
+	if l.file == "" {
+		return
+	}
+	data, err := os.ReadFile(l.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &l.profiles)
+	}
+	if err != nil {
+		log.Printf("⚠️ Ignoring unreadable send quota file %s: %v", l.file, err)
+		l.profiles = make(map[string]sendLog)
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSendLimiterWindows(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newSendLimiter([]quotaWindow{
+		{name: "second", limit: 2, period: time.Second},
+		{name: "minute", limit: 0, period: time.Minute}, // Unlimited windows are dropped
+		{name: "day", limit: 3, period: 24 * time.Hour},
+	}, 0, "")
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := range 2 {
+		if err := l.reserve(ctx, "a@example.com", PriorityTransactional); err != nil {
+			t.Fatalf("send %d refused: %v", i, err)
+		}
+	}
+
+	var quotaErr *QuotaError
+	err := l.reserve(ctx, "a@example.com", PriorityTransactional)
+	if !errors.As(err, &quotaErr) || quotaErr.Window != "second" || !errors.Is(err, ErrSendQuotaExceeded) {
+		t.Fatalf("Expected the per-second window to refuse, got %v", err)
+	}
+
+	now = now.Add(time.Second)
+	if err := l.reserve(ctx, "a@example.com", PriorityTransactional); err != nil {
+		t.Fatalf("send a second later refused: %v", err)
+	}
+	err = l.check("a@example.com", PriorityTransactional)
+	if !errors.As(err, &quotaErr) || quotaErr.Window != "day" || quotaErr.RetryAfter != 24*time.Hour-time.Second {
+		t.Fatalf("Expected the day window to refuse until the first send is a day old, got %v", err)
+	}
+
+	// Profiles have their own quota
+	if err := l.reserve(ctx, "b@example.com", PriorityTransactional); err != nil {
+		t.Fatalf("other profile refused: %v", err)
+	}
+
+	q := l.remaining("a@example.com")["a@example.com"]
+	if q.Minute != nil || q.Second == nil || *q.Second != 1 || q.Day == nil || *q.Day != 0 {
+		t.Errorf("Unexpected remaining quota %+v", q)
+	}
+}
+
+func TestSendLimiterDayIsAFullDay(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newSendLimiter([]quotaWindow{{name: "day", limit: 10, period: 24 * time.Hour}}, 0, "")
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Sends spread over the day never refill the window before it has passed
+	for i := range 10 {
+		if err := l.reserve(ctx, "a@example.com", PriorityTransactional); err != nil {
+			t.Fatalf("send %d refused: %v", i, err)
+		}
+		now = now.Add(2 * time.Hour)
+	}
+	for now.Before(time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		if err := l.reserve(ctx, "a@example.com", PriorityTransactional); !errors.Is(err, ErrSendQuotaExceeded) {
+			t.Fatalf("Expected send 11 within 24h to be refused at %v, got %v", now, err)
+		}
+		now = now.Add(30 * time.Minute)
+	}
+	if err := l.reserve(ctx, "a@example.com", PriorityTransactional); err != nil {
+		t.Errorf("Expected a send once the first is a day old, got %v", err)
+	}
+}
+
+func TestSendLimiterWaitsForWindow(t *testing.T) {
+	l := newSendLimiter([]quotaWindow{{name: "second", limit: 20, period: 100 * time.Millisecond}}, time.Second, "")
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := range 21 {
+		if err := l.reserve(ctx, "a@example.com", PriorityTransactional); err != nil {
+			t.Fatalf("send %d refused: %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the 21st send to wait for the window, took %v", elapsed)
+	}
+}
+
+func TestSendLimiterPersistsUsage(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quota.json")
+	windows := []quotaWindow{{name: "day", limit: 2, period: 24 * time.Hour}}
+
+	l := newSendLimiter(windows, 0, file)
+	for range 2 {
+		if err := l.reserve(context.Background(), "a@example.com", PriorityTransactional); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.mu.Lock()
+	l.saveLocked()
+	l.mu.Unlock()
+
+	restarted := newSendLimiter(windows, 0, file)
+	if err := restarted.check("a@example.com", PriorityTransactional); !errors.Is(err, ErrSendQuotaExceeded) {
+		t.Errorf("Expected the daily quota to survive a restart, got %v", err)
+	}
+}
+
+func TestSendLimiterPriorities(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newSendLimiter([]quotaWindow{{name: "day", limit: 100, period: 24 * time.Hour}}, 0, "")
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Bulk sends stop short of the reserve kept for other mail
+	for i := range 95 {
+		if err := l.reserve(ctx, "a@example.com", PriorityBulk); err != nil {
+			t.Fatalf("bulk send %d refused: %v", i, err)
+		}
+	}
+	if err := l.check("a@example.com", PriorityBulk); !errors.Is(err, ErrSendQuotaExceeded) {
+		t.Errorf("Expected bulk to leave the reserve alone, got %v", err)
+	}
+	if err := l.reserve(ctx, "a@example.com", PriorityTransactional); err != nil {
+		t.Errorf("Expected transactional mail to use the reserve, got %v", err)
+	}
+
+	// A waiting transactional sender keeps the last send from normal senders
+	l.waiting[PriorityTransactional]++
+	for range 3 {
+		if err := l.reserve(ctx, "a@example.com", PriorityNormal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.check("a@example.com", PriorityNormal); !errors.Is(err, ErrSendQuotaExceeded) {
+		t.Errorf("Expected normal mail to wait behind transactional, got %v", err)
+	}
+	l.waiting[PriorityTransactional]--
+	if err := l.reserve(ctx, "a@example.com", PriorityTransactional); err != nil {
+		t.Errorf("Expected the last send to go to transactional mail, got %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"Form-Mailly-Go/internal/config"
+	"Form-Mailly-Go/internal/monitoring"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSMTPSaturated is returned when every SMTP connection slot is taken and no
+// slot freed up within SMTP_QUEUE_TIMEOUT (or the wait queue is full).
+var ErrSMTPSaturated = errors.New("all SMTP connections are busy, try again later")
+
+// ContactTenant is the tenant name used for single contact form sends.
+const ContactTenant = "contact"
+
+// slotQuantum is how long a tenant may keep its last slots while a tenant
+// holding none is waiting. It lets senders take turns when there are more
+// tenants than connections.
+const slotQuantum = 5 * time.Second
+
+// slotScheduler caps the SMTP connections open across the whole process and
+// shares them between tenants: each running batch is a tenant, and so are
+// contact form sends. A freed slot goes to the waiter of the highest priority
+// class, then to the tenant holding the fewest slots, oldest waiter first.
+// Each class may also be capped below the total, so bulk traffic never takes
+// every connection.
+type slotScheduler struct {
+	mu         sync.Mutex
+	capacity   int
+	classLimit [PriorityTransactional + 1]int // Most slots each priority class may hold
+	maxQueue   int
+	timeout    time.Duration
+	inUse      int
+	classHeld  [PriorityTransactional + 1]int
+	holders    map[string]int // Slots held per tenant
+	waiters    []*slotWaiter  // In arrival order
+}
+
+type slotWaiter struct {
+	tenant   string
+	priority Priority
+	since    time.Time
+	ready    chan struct{} // Closed once the slot is granted
+	granted  bool
+}
+
+// SMTPSlot is a permit to hold one SMTP connection. Release it exactly once.
+type SMTPSlot struct {
+	scheduler *slotScheduler
+	tenant    string
+	priority  Priority
+	grantedAt time.Time
+	once      sync.Once
+}
+
+var (
+	scheduler     *slotScheduler
+	schedulerOnce sync.Once
+)
+
+// slots returns the process-wide scheduler, created from the configuration on first use.
+func slots() *slotScheduler {
+	schedulerOnce.Do(func() {
+		capacity := config.EnvVar.SMTPMaxConnections
+		scheduler = newSlotScheduler(capacity, config.EnvVar.SMTPMaxQueue, config.EnvVar.SMTPQueueTimeout)
+
+		// By default bulk batches leave one connection for everything else
+		scheduler.setClassLimit(PriorityBulk, config.EnvVar.SMTPBulkConnections, max(1, capacity-1))
+		scheduler.setClassLimit(PriorityNormal, config.EnvVar.SMTPNormalConnections, capacity)
+	})
+	return scheduler
+}
+
+func newSlotScheduler(capacity, maxQueue int, timeout time.Duration) *slotScheduler {
+	s := &slotScheduler{capacity: capacity, maxQueue: maxQueue, timeout: timeout, holders: make(map[string]int)}
+	for class := range s.classLimit {
+		s.classLimit[class] = capacity
+	}
+	return s
+}
+
+// setClassLimit caps the slots of one class at limit, or def when limit is 0.
+func (s *slotScheduler) setClassLimit(class Priority, limit, def int) {
+	if limit == 0 {
+		limit = def
+	}
+	s.classLimit[class] = min(limit, s.capacity)
+}
+
+// AcquireSMTPSlot waits for a connection slot for tenant, queueing behind
+// other senders for up to SMTP_QUEUE_TIMEOUT. It returns ErrSMTPSaturated
+// when no slot frees up in time or the queue is already full.
+func AcquireSMTPSlot(ctx context.Context, tenant string, priority Priority) (*SMTPSlot, error) {
+	return slots().acquire(ctx, tenant, priority)
+}
+
+// TryAcquireSMTPSlot takes a slot only if one is free and nobody eligible is
+// queued, for extra batch workers that should never delay other senders.
+func TryAcquireSMTPSlot(tenant string, priority Priority) (*SMTPSlot, bool) {
+	s := slots()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.canGrantLocked(priority) || s.nextWaiterLocked(time.Now()) >= 0 {
+		return nil, false
+	}
+	s.grantLocked(tenant, priority)
+	return &SMTPSlot{scheduler: s, tenant: tenant, priority: priority, grantedAt: time.Now()}, true
+}
+
+// SMTPRetryAfter is the delay suggested to clients rejected with ErrSMTPSaturated.
+func SMTPRetryAfter() time.Duration {
+	return slots().timeout
+}
+
+// Release returns the slot to the scheduler.
+func (slot *SMTPSlot) Release() {
+	slot.once.Do(func() {
+		s := slot.scheduler
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.releaseLocked(slot.tenant, slot.priority)
+	})
+}
+
+// ShouldYield reports whether this slot should be handed to a waiting sender:
+// right away when the waiter is of a higher class, and otherwise when this
+// tenant holds more than its fair share, or a tenant holding no slot at all
+// has been waiting while this slot was held for a full quantum. Batch workers
+// check it between emails, so a running batch never keeps everyone else
+// waiting.
+func (slot *SMTPSlot) ShouldYield() bool {
+	s := slot.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	tenants := make(map[string]bool, len(s.holders)+len(s.waiters))
+	for tenant := range s.holders {
+		tenants[tenant] = true
+	}
+	waiting, starving := false, false
+	for _, w := range s.waiters {
+		// Waiters whose class is at its cap could not use this slot anyway
+		held := s.classHeld[w.priority]
+		if w.priority == slot.priority {
+			held--
+		}
+		if held >= s.classLimit[w.priority] {
+			continue
+		}
+
+		switch priority := w.priority.aged(now.Sub(w.since)); {
+		case priority > slot.priority:
+			return true
+		case priority == slot.priority:
+			waiting = true
+			tenants[w.tenant] = true
+			if s.holders[w.tenant] == 0 {
+				starving = true
+			}
+		}
+	}
+	if !waiting {
+		return false
+	}
+
+	fairShare := max(1, s.capacity/len(tenants))
+	if s.holders[slot.tenant] > fairShare {
+		return true
+	}
+	return starving && now.Sub(slot.grantedAt) >= slotQuantum
+}
+
+func (s *slotScheduler) acquire(ctx context.Context, tenant string, priority Priority) (*SMTPSlot, error) {
+	s.mu.Lock()
+	if s.canGrantLocked(priority) && s.nextWaiterLocked(time.Now()) < 0 {
+		s.grantLocked(tenant, priority)
+		s.mu.Unlock()
+		return &SMTPSlot{scheduler: s, tenant: tenant, priority: priority, grantedAt: time.Now()}, nil
+	}
+	if len(s.waiters) >= s.maxQueue {
+		s.mu.Unlock()
+		return nil, ErrSMTPSaturated
+	}
+	w := &slotWaiter{tenant: tenant, priority: priority, since: time.Now(), ready: make(chan struct{})}
+	s.waiters = append(s.waiters, w)
+	s.dispatchLocked()
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		return &SMTPSlot{scheduler: s, tenant: tenant, priority: priority, grantedAt: time.Now()}, nil
+	case <-timer.C:
+		err = ErrSMTPSaturated
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.granted {
+		// The slot arrived just as we gave up: keep it unless the caller is gone
+		if errors.Is(err, ErrSMTPSaturated) {
+			return &SMTPSlot{scheduler: s, tenant: tenant, priority: priority, grantedAt: time.Now()}, nil
+		}
+		s.releaseLocked(tenant, priority)
+		return nil, err
+	}
+	for i, waiting := range s.waiters {
+		if waiting == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			break
+		}
+	}
+	s.reportLocked()
+	return nil, err
+}
+
+// canGrantLocked reports whether a slot is free for the class. Callers must hold s.mu.
+func (s *slotScheduler) canGrantLocked(priority Priority) bool {
+	return s.inUse < s.capacity && s.classHeld[priority] < s.classLimit[priority]
+}
+
+// grantLocked hands a slot to tenant. Callers must hold s.mu.
+func (s *slotScheduler) grantLocked(tenant string, priority Priority) {
+	s.inUse++
+	s.classHeld[priority]++
+	s.holders[tenant]++
+	s.reportLocked()
+}
+
+// releaseLocked frees one of tenant's slots and passes it on. Callers must hold s.mu.
+func (s *slotScheduler) releaseLocked(tenant string, priority Priority) {
+	s.inUse--
+	s.classHeld[priority]--
+	if s.holders[tenant]--; s.holders[tenant] <= 0 {
+		delete(s.holders, tenant)
+	}
+	s.dispatchLocked()
+}
+
+// nextWaiterLocked returns the index of the waiter to serve next, or -1 when
+// no waiter can be granted a slot now. Callers must hold s.mu.
+func (s *slotScheduler) nextWaiterLocked(now time.Time) int {
+	next, nextPriority := -1, Priority(0)
+	for i, w := range s.waiters {
+		if !s.canGrantLocked(w.priority) {
+			continue
+		}
+		priority := w.priority.aged(now.Sub(w.since))
+		if next < 0 || priority > nextPriority ||
+			(priority == nextPriority && s.holders[w.tenant] < s.holders[s.waiters[next].tenant]) {
+			next, nextPriority = i, priority
+		}
+	}
+	return next
+}
+
+// dispatchLocked hands free slots to waiters in priority order. Callers must hold s.mu.
+func (s *slotScheduler) dispatchLocked() {
+	now := time.Now()
+	for {
+		next := s.nextWaiterLocked(now)
+		if next < 0 {
+			break
+		}
+		w := s.waiters[next]
+		s.waiters = append(s.waiters[:next], s.waiters[next+1:]...)
+		w.granted = true
+		s.grantLocked(w.tenant, w.priority)
+		close(w.ready)
+	}
+	s.reportLocked()
+}
+
+// reportLocked publishes the scheduler state to the metrics. Callers must hold s.mu.
+func (s *slotScheduler) reportLocked() {
+	monitoring.SetSMTPConnections(s.inUse, len(s.waiters))
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSchedulerSaturation(t *testing.T) {
+	s := newSlotScheduler(1, 1, 20*time.Millisecond)
+	ctx := context.Background()
+
+	held, err := s.acquire(ctx, "batch-a", PriorityNormal)
+	if err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+
+	if _, err := s.acquire(ctx, "contact", PriorityNormal); !errors.Is(err, ErrSMTPSaturated) {
+		t.Errorf("Expected ErrSMTPSaturated after waiting, got %v", err)
+	}
+
+	held.Release()
+	slot, err := s.acquire(ctx, "contact", PriorityNormal)
+	if err != nil {
+		t.Fatalf("acquire after release failed: %v", err)
+	}
+	slot.Release()
+
+	if s.inUse != 0 || len(s.holders) != 0 {
+		t.Errorf("Expected every slot back, inUse=%d holders=%v", s.inUse, s.holders)
+	}
+}
+
+func TestSchedulerPrefersTenantWithFewestSlots(t *testing.T) {
+	s := newSlotScheduler(2, 10, time.Second)
+	ctx := context.Background()
+
+	first, _ := s.acquire(ctx, "batch-a", PriorityNormal)
+	second, _ := s.acquire(ctx, "batch-a", PriorityNormal)
+
+	// batch-a queues for a third slot before the contact send arrives
+	got := make(chan string, 2)
+	for _, tenant := range []string{"batch-a", "contact"} {
+		go func() {
+			slot, err := s.acquire(ctx, tenant, PriorityNormal)
+			if err == nil {
+				got <- tenant
+				slot.Release()
+			}
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !second.ShouldYield() {
+		t.Error("Expected batch-a to be asked to yield while holding both slots")
+	}
+
+	first.Release()
+	if tenant := <-got; tenant != "contact" {
+		t.Errorf("Freed slot went to %q, want contact", tenant)
+	}
+	second.Release()
+	<-got
+}
+
+func TestSchedulerServesHigherPriorityFirst(t *testing.T) {
+	s := newSlotScheduler(2, 10, time.Second)
+	s.setClassLimit(PriorityBulk, 0, 1)
+	ctx := context.Background()
+
+	bulk, err := s.acquire(ctx, "batch-a", PriorityBulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bulk is capped below the total, so the second slot stays free for contact mail
+	if _, err := s.acquire(ctx, "batch-b", PriorityBulk); !errors.Is(err, ErrSMTPSaturated) {
+		t.Errorf("Expected bulk to be capped at one slot, got %v", err)
+	}
+	contact, err := s.acquire(ctx, ContactTenant, PriorityTransactional)
+	if err != nil {
+		t.Fatalf("Expected a slot for contact mail, got %v", err)
+	}
+
+	got := make(chan string, 2)
+	for _, w := range []struct {
+		tenant   string
+		priority Priority
+	}{{"batch-b", PriorityNormal}, {ContactTenant, PriorityTransactional}} {
+		go func() {
+			slot, err := s.acquire(ctx, w.tenant, w.priority)
+			if err == nil {
+				got <- w.tenant
+				slot.Release()
+			}
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !bulk.ShouldYield() {
+		t.Error("Expected bulk to yield to a waiting transactional send")
+	}
+	contact.Release()
+	if tenant := <-got; tenant != ContactTenant {
+		t.Errorf("Freed slot went to %q, want %s", tenant, ContactTenant)
+	}
+	bulk.Release()
+	<-got
+}
+
+func TestPriorityAging(t *testing.T) {
+	if got := PriorityBulk.aged(priorityAging); got != PriorityNormal {
+		t.Errorf("bulk after one aging period = %v, want normal", got)
+	}
+	if got := PriorityBulk.aged(10 * priorityAging); got != PriorityNormal {
+		t.Errorf("aging reached %v, want it to stop below transactional", got)
+	}
+}
+
+func TestAgedBulkWaitsBehindTransactional(t *testing.T) {
+	s := newSlotScheduler(1, 10, 5*time.Second)
+	ctx := context.Background()
+
+	held, err := s.acquire(ctx, "batch-a", PriorityBulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan string, 2)
+	wait := func(tenant string, priority Priority) {
+		slot, err := s.acquire(ctx, tenant, priority)
+		if err == nil {
+			got <- tenant
+			slot.Release()
+		}
+	}
+	go wait("batch-b", PriorityBulk)
+	time.Sleep(10 * time.Millisecond)
+	s.mu.Lock()
+	s.waiters[0].since = time.Now().Add(-10 * priorityAging) // The bulk waiter has aged as far as it can
+	s.mu.Unlock()
+	go wait(ContactTenant, PriorityTransactional)
+	time.Sleep(10 * time.Millisecond)
+
+	held.Release()
+	if tenant := <-got; tenant != ContactTenant {
+		t.Errorf("Freed slot went to %q, want %s", tenant, ContactTenant)
+	}
+	<-got
+}
@@ -0,0 +1,133 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// custom holds the templates loaded from TEMPLATE_DIR.
+var custom struct {
+	mu     sync.Mutex
+	dir    string
+	stamp  string                    // Names, sizes and times of the files last read
+	loaded map[string]*emailTemplate // Last good version of every template in dir
+}
+
+// LoadDir adds the templates in dir to the built-in ones: <name>.html, with an
+// optional <name>.txt plain-text body and <name>.subject subject line, all in
+// Go template syntax over the same data as the built-in templates. Each one is
+// rendered with a sample submission first; the returned error lists those that
+// failed and were left out. An empty dir keeps the built-in templates only.
+func LoadDir(dir string) error {
+	custom.mu.Lock()
+	defer custom.mu.Unlock()
+
+	custom.dir, custom.stamp, custom.loaded = dir, "", nil
+	if dir == "" {
+		registry.Store(&builtin)
+		return nil
+	}
+	return reloadLocked()
+}
+
+// Reload reads the template directory again. A template whose new version
+// fails to parse or render keeps its last good version, and templates whose
+// files were removed are dropped.
+func Reload() error {
+	custom.mu.Lock()
+	defer custom.mu.Unlock()
+
+	if custom.dir == "" {
+		return nil
+	}
+	return reloadLocked()
+}
+
+// WatchDir reloads the template directory whenever its files change, checking
+// every interval, and logs the outcome.
+func WatchDir(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if changed() {
+				LogReload(Reload())
+			}
+		}
+	}()
+}
+
+// LogReload reports the outcome of a reload, as returned by LoadDir or Reload.
+func LogReload(err error) {
+	if err != nil {
+		fmt.Printf("⚠️ Templates reloaded with errors, keeping the last good versions: %v\n", err)
+		return
+	}
+	fmt.Printf("Templates loaded: %s\n", strings.Join(Names(), ", "))
+}
+
+// changed reports whether the directory looks different from the last reload.
+func changed() bool {
+	custom.mu.Lock()
+	defer custom.mu.Unlock()
+
+	if custom.dir == "" {
+		return false
+	}
+	stamp, _ := dirStamp(custom.dir) // An unreadable directory is reported once, when it becomes so
+	return stamp != custom.stamp
+}
+
+func reloadLocked() error {
+	// Taken before reading, so a file written meanwhile is read again next time
+	stamp, err := dirStamp(custom.dir)
+	if err != nil {
+		custom.stamp = ""
+		return fmt.Errorf("cannot read template directory: %w", err)
+	}
+	custom.stamp = stamp
+
+	parsed, errs := parseTemplates(os.DirFS(custom.dir))
+	loaded := make(map[string]*emailTemplate, len(parsed))
+	for name, tmpl := range custom.loaded {
+		if errs[name] != nil {
+			loaded[name] = tmpl
+		}
+	}
+	maps.Copy(loaded, parsed)
+	custom.loaded = loaded
+
+	next := maps.Clone(builtin)
+	maps.Copy(next, loaded)
+	registry.Store(&next)
+
+	var failures []error
+	for _, name := range slices.Sorted(maps.Keys(errs)) {
+		failures = append(failures, fmt.Errorf("template %q: %v", name, errs[name]))
+	}
+	return errors.Join(failures...)
+}
+
+// dirStamp summarizes the names, sizes and modification times of the files in dir.
+func dirStamp(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var stamp strings.Builder
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed since the listing
+		}
+		fmt.Fprintf(&stamp, "%s:%d:%d\n", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp.String(), nil
+}
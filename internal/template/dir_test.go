@@ -0,0 +1,94 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeTemplateFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Cleanup(func() { _ = LoadDir("") })
+
+	writeTemplateFile(t, dir, "brand.html", "<p>{{.Message}}</p>")
+	writeTemplateFile(t, dir, "brand.txt", "From {{.Name}}: {{.Message}}")
+	writeTemplateFile(t, dir, "brand.subject", "[{{.ProductName}}]\n{{.Subject}}")
+	writeTemplateFile(t, dir, "typo.html", "<p>{{.Mesage}}</p>")
+	writeTemplateFile(t, dir, "orphan.txt", "no html")
+	writeTemplateFile(t, dir, "notes.md", "ignored")
+
+	if err := LoadDir(dir); err == nil {
+		t.Error("Expected errors for typo and orphan")
+	}
+	names := Names()
+	if !slices.Contains(names, "brand") || !slices.Contains(names, "card") {
+		t.Errorf("Names() = %v, want the custom and built-in templates", names)
+	}
+	if slices.Contains(names, "typo") || slices.Contains(names, "orphan") {
+		t.Errorf("Names() = %v, templates failing to load should be left out", names)
+	}
+
+	message, err := Render("brand", nil, sampleContact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Subject != "[Example Product] Question about your product" {
+		t.Errorf("Subject = %q", message.Subject)
+	}
+	if message.Text != "From Ada Lovelace: Hello,\nI would like to know more." {
+		t.Errorf("Text = %q", message.Text)
+	}
+	if message.HTML != "<p>Hello,\nI would like to know more.</p>" {
+		t.Errorf("HTML = %q", message.HTML)
+	}
+}
+
+func TestReloadKeepsLastGoodVersion(t *testing.T) {
+	dir := t.TempDir()
+	t.Cleanup(func() { _ = LoadDir("") })
+
+	writeTemplateFile(t, dir, "brand.html", "<p>v1 {{.Name}}</p>")
+	writeTemplateFile(t, dir, "card.html", "<p>my card</p>")
+	if err := LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if message, _ := Render("card", nil, sampleContact); message.HTML != "<p>my card</p>" {
+		t.Errorf("A custom template should replace the built-in one, got %q", message.HTML)
+	}
+
+	writeTemplateFile(t, dir, "brand.html", "<p>v2 {{.Name</p>")
+	if err := Reload(); err == nil {
+		t.Error("Expected a parse error")
+	}
+	if message, _ := Render("brand", nil, sampleContact); message.HTML != "<p>v1 Ada Lovelace</p>" {
+		t.Errorf("Expected the last good version, got %q", message.HTML)
+	}
+
+	writeTemplateFile(t, dir, "brand.html", "<p>v3 {{.Name}}</p>")
+	if err := os.Remove(filepath.Join(dir, "card.html")); err != nil {
+		t.Fatal(err)
+	}
+	if !changed() {
+		t.Error("changed() should notice the edits")
+	}
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if changed() {
+		t.Error("changed() should be false right after a reload")
+	}
+	if message, _ := Render("brand", nil, sampleContact); message.HTML != "<p>v3 Ada Lovelace</p>" {
+		t.Errorf("Expected the new version, got %q", message.HTML)
+	}
+	if message, _ := Render("card", nil, sampleContact); message.HTML == "<p>my card</p>" {
+		t.Error("Removing the custom template should restore the built-in one")
+	}
+}
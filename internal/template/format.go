@@ -0,0 +1,83 @@
+package template
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"cmp"
+	"errors"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Formats a message can be written in.
+const (
+	FormatHTML     = "html"     // Sent as written; the default for batch entries
+	FormatMarkdown = "markdown" // Rendered by Markdown
+	FormatText     = "text"     // Escaped, keeping its line breaks; the default for contact submissions
+)
+
+// DefaultLayout wraps batch messages written in Markdown or text unless the entry names a template.
+const DefaultLayout = "message"
+
+// ErrUnknownFormat matches the error returned for a format that does not exist.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// CheckFormat returns an error when format is set to something other than one of allowed.
+func CheckFormat(format string, allowed ...string) error {
+	if format == "" || slices.Contains(allowed, format) {
+		return nil
+	}
+	return fmt.Errorf("%w %q, use one of: %s", ErrUnknownFormat, format, strings.Join(allowed, ", "))
+}
+
+// FormatBody turns a message in format into the HTML templates show as {{.Body}}.
+// Only FormatHTML is trusted as is, so it must not come from visitors.
+func FormatBody(format, message string) htmltemplate.HTML {
+	switch format {
+	case FormatHTML:
+		return htmltemplate.HTML(message)
+	case FormatMarkdown:
+		return Markdown(message)
+	default:
+		escaped := html.EscapeString(strings.ReplaceAll(message, "\r\n", "\n"))
+		return htmltemplate.HTML(strings.ReplaceAll(escaped, "\n", "<br>\n"))
+	}
+}
+
+// RenderEntry turns a batch entry's message into the HTML it is sent as:
+// Markdown and text are rendered into the entry's template, or DefaultLayout,
+// and so is HTML when the entry names a template. HTML entries without one
+// are sent as written. Layouts are rendered in locale, showing times in the
+// timezone of at, when the entry is sent.
+func RenderEntry(email *model.Email, locale *Locale, at time.Time) error {
+	if email.Format == "" || email.Format == FormatHTML {
+		if email.Template == "" {
+			return nil
+		}
+	}
+	layout := email.Template
+	if layout == "" {
+		layout = DefaultLayout
+	}
+
+	data := ContactData{
+		ContactForm: &model.ContactForm{Subject: email.Subject, Message: email.Message, ProductName: email.ProductName},
+		Submitted:   NewTime(at, locale),
+		SubmittedAt: locale.FormatTime(at),
+		Body:        FormatBody(cmp.Or(email.Format, FormatHTML), email.Message),
+		Locale:      locale.Tag,
+		T:           locale.Labels,
+	}
+	message, err := Render(layout, locale, data)
+	if err != nil {
+		return err
+	}
+	email.Message, email.Text = message.HTML, message.Text
+	if message.Subject != "" {
+		email.Subject = message.Subject
+	}
+	return nil
+}
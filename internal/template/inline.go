@@ -0,0 +1,609 @@
+package template
+
+import (
+	"crypto/sha256"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// styleBlock matches a <style> element, capturing its CSS.
+var styleBlock = regexp.MustCompile(`(?is)<style\b[^>]*>(.*?)</style\s*>`)
+
+// cssRule is one selector of a style block with its declarations. Rules are
+// applied by specificity, then in the order they were written.
+type cssRule struct {
+	selector    []cssCompound // Rightmost last
+	combinators []byte        // ' ' or '>' between each compound and the next
+	specificity [3]int        // IDs, classes, element names
+	order       int
+	decls       []cssDecl
+}
+
+// cssCompound is a selector without combinators, such as td.label or #main.
+type cssCompound struct {
+	tag     string // Empty or "*" for any element
+	id      string
+	classes []string
+}
+
+type cssDecl struct {
+	property  string
+	value     string
+	important bool
+}
+
+// cssElement is an open element, as selectors see it.
+type cssElement struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+// inlined caches InlineCSS by the hash of its source, so reloading a
+// template directory only inlines the templates that changed.
+var inlined = struct {
+	sync.Mutex
+	results map[[sha256.Size]byte]string
+}{results: make(map[[sha256.Size]byte]string)}
+
+// inlineCacheSize bounds inlined; it is emptied when full.
+const inlineCacheSize = 256
+
+// cachedInlineCSS is InlineCSS, remembered for sources it has seen.
+func cachedInlineCSS(source string) string {
+	if !strings.Contains(source, "<style") && !strings.Contains(source, "<STYLE") {
+		return source
+	}
+	key := sha256.Sum256([]byte(source))
+	inlined.Lock()
+	defer inlined.Unlock()
+	if result, ok := inlined.results[key]; ok {
+		return result
+	}
+	if len(inlined.results) >= inlineCacheSize {
+		clear(inlined.results)
+	}
+	result := InlineCSS(source)
+	inlined.results[key] = result
+	return result
+}
+
+// InlineCSS moves the rules of the <style> blocks in an HTML template into
+// the style attributes of the elements they select, since many email clients
+// drop <style>. Selectors may use element names, classes, IDs and the
+// descendant and child combinators; rules they cannot express, such as
+// @media queries and :hover, stay in their block, which is removed once it is
+// empty. Declarations land before the element's own style, so that still
+// wins, unless they are !important. Blocks and class attributes holding
+// template actions are left alone, as their value is only known when sent.
+func InlineCSS(source string) string {
+	blocks := styleBlock.FindAllStringSubmatchIndex(source, -1)
+	var rules []cssRule
+	kept := make([]string, len(blocks))
+	for i, block := range blocks {
+		css := source[block[2]:block[3]]
+		if strings.Contains(css, "{{") {
+			kept[i] = source[block[0]:block[1]]
+			continue
+		}
+		var leftover []string
+		rules, leftover = parseCSS(css, rules)
+		if len(leftover) > 0 {
+			// Keep the indentation of the block
+			body := strings.TrimLeft(css, "\r\n")
+			indent := body[:len(body)-len(strings.TrimLeft(body, " \t"))]
+			trailing := css[len(strings.TrimRight(css, " \t\r\n")):]
+			kept[i] = source[block[0]:block[2]] + "\n" + indent + strings.Join(leftover, "\n"+indent) + trailing + source[block[3]:block[1]]
+		} else {
+			// An emptied block goes with its line
+			start, end := block[0], block[1]
+			for start > 0 && (source[start-1] == ' ' || source[start-1] == '\t') {
+				start--
+			}
+			rest := strings.TrimLeft(source[end:], " \t\r")
+			if (start == 0 || source[start-1] == '\n') && strings.HasPrefix(rest, "\n") {
+				block[0], block[1] = start, len(source)-len(rest)+1
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return source
+	}
+	slices.SortStableFunc(rules, func(a, b cssRule) int {
+		if c := slices.Compare(a.specificity[:], b.specificity[:]); c != 0 {
+			return c
+		}
+		return a.order - b.order
+	})
+
+	var out strings.Builder
+	var open []cssElement
+	next := 0 // Index of the next style block
+	for i := 0; i < len(source); {
+		if next < len(blocks) && i == blocks[next][0] {
+			out.WriteString(kept[next])
+			i = blocks[next][1]
+			next++
+			continue
+		}
+
+		rest := source[i:]
+		var end int
+		switch {
+		case strings.HasPrefix(rest, "{{"):
+			end = lengthPast(rest, "}}")
+		case strings.HasPrefix(rest, "<!--"):
+			end = lengthPast(rest, "-->")
+		case len(rest) > 2 && rest[0] == '<' && rest[1] == '/' && isASCIILetter(rest[2]):
+			name, _ := tagName(rest[2:])
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j].tag == name {
+					open = open[:j] // Elements left open end with their parent
+					break
+				}
+			}
+			end = tagEnd(rest)
+		case len(rest) > 1 && rest[0] == '<' && isASCIILetter(rest[1]):
+			end = tagEnd(rest)
+			tag, element, selfClosing := rewriteStartTag(rest[:end], rules, &open)
+			out.WriteString(tag)
+			if rawTextElements[element.tag] {
+				// Copy the content as it is, up to the end tag
+				if j := indexFold(rest[end:], "</"+element.tag); j >= 0 {
+					out.WriteString(rest[end : end+j])
+					end += j
+				}
+			} else if !voidElements[element.tag] && !selfClosing {
+				open = append(open, element)
+			}
+			i += end
+			continue
+		default:
+			end = len(rest)
+			for _, stop := range []string{"<", "{{"} {
+				if j := strings.Index(rest[1:], stop); j >= 0 {
+					end = min(end, j+1)
+				}
+			}
+			if next < len(blocks) {
+				end = min(end, blocks[next][0]-i)
+			}
+		}
+		out.WriteString(rest[:end])
+		i += end
+	}
+	return out.String()
+}
+
+// lengthPast returns the length of s up to and including the first marker, or
+// all of s when there is none.
+func lengthPast(s, marker string) int {
+	if i := strings.Index(s, marker); i >= 0 {
+		return i + len(marker)
+	}
+	return len(s)
+}
+
+// tagEnd returns the length of the tag s starts with, which ends at the first
+// ">" outside quotes and template actions.
+func tagEnd(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"):
+			i += lengthPast(s[i:], "}}") - 1
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == '>':
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// cssComment matches a CSS comment.
+var cssComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// compoundSelector matches a selector without combinators: an optional
+// element name or "*", then any number of classes and IDs.
+var compoundSelector = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*|\*)?((?:[.#][a-zA-Z_-][a-zA-Z0-9_-]*)*)$`)
+
+// parseCSS appends the inlinable rules of a style block to rules, numbered
+// after them, and returns the rules that have to stay in the block.
+func parseCSS(css string, rules []cssRule) ([]cssRule, []string) {
+	var leftover []string
+	css = cssComment.ReplaceAllString(css, "")
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			break
+		}
+		if css[0] == '@' {
+			// At-rules, with or without a block, are kept as written
+			end := len(css)
+			if brace, semicolon := strings.IndexByte(css, '{'), strings.IndexByte(css, ';'); brace >= 0 && (semicolon < 0 || brace < semicolon) {
+				end = brace + closingBrace(css[brace:]) + 1
+			} else if semicolon >= 0 {
+				end = semicolon + 1
+			}
+			leftover = append(leftover, css[:end])
+			css = css[end:]
+			continue
+		}
+
+		brace := strings.IndexByte(css, '{')
+		if brace < 0 {
+			break
+		}
+		end := brace + closingBrace(css[brace:])
+		selectors, body := css[:brace], css[brace+1:min(end, len(css))]
+		css = css[min(end+1, len(css)):]
+
+		decls := parseDeclarations(body)
+		var kept []string
+		for selector := range strings.SplitSeq(selectors, ",") {
+			rule, ok := compileSelector(selector)
+			if !ok {
+				kept = append(kept, strings.TrimSpace(selector))
+				continue
+			}
+			rule.order, rule.decls = len(rules), decls
+			rules = append(rules, rule)
+		}
+		if len(kept) > 0 {
+			leftover = append(leftover, strings.Join(kept, ", ")+" {"+strings.TrimSpace(body)+"}")
+		}
+	}
+	return rules, leftover
+}
+
+// closingBrace returns the index of the brace closing the block s starts
+// with, or len(s) when it is not closed.
+func closingBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+// parseDeclarations splits the body of a rule into its declarations. A
+// semicolon inside quotes or parentheses, as in a data: URL, does not end one.
+func parseDeclarations(body string) []cssDecl {
+	var decls []cssDecl
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i <= len(body); i++ {
+		if i < len(body) {
+			switch c := body[i]; {
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+				continue
+			case c == '"' || c == '\'':
+				quote = c
+				continue
+			case c == '(':
+				depth++
+				continue
+			case c == ')':
+				depth--
+				continue
+			case c != ';' || depth > 0:
+				continue
+			}
+		}
+		property, value, ok := strings.Cut(body[start:i], ":")
+		start = i + 1
+		property, value = strings.ToLower(strings.TrimSpace(property)), strings.TrimSpace(value)
+		if !ok || property == "" || value == "" {
+			continue
+		}
+		decl := cssDecl{property: property, value: value}
+		if i := strings.LastIndexByte(value, '!'); i >= 0 && strings.EqualFold(strings.TrimSpace(value[i+1:]), "important") {
+			decl.value, decl.important = strings.TrimSpace(value[:i]), true
+		}
+		decls = append(decls, decl)
+	}
+	return decls
+}
+
+// compileSelector reads one selector of a rule, reporting false for those
+// that cannot be inlined, such as ones with pseudo-classes or attributes.
+func compileSelector(selector string) (cssRule, bool) {
+	var rule cssRule
+	child := false
+	for field := range strings.FieldsSeq(strings.ReplaceAll(selector, ">", " > ")) {
+		if field == ">" {
+			if len(rule.selector) == 0 || child {
+				return rule, false
+			}
+			child = true
+			continue
+		}
+		m := compoundSelector.FindStringSubmatch(field)
+		if m == nil {
+			return rule, false
+		}
+		compound := cssCompound{tag: strings.ToLower(m[1])}
+		if compound.tag != "" && compound.tag != "*" {
+			rule.specificity[2]++
+		}
+		for rest := m[2]; rest != ""; {
+			end := strings.IndexAny(rest[1:], ".#") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if rest[0] == '#' {
+				if compound.id != "" && compound.id != rest[1:end] {
+					return rule, false // Selects nothing
+				}
+				compound.id = rest[1:end]
+				rule.specificity[0]++
+			} else {
+				compound.classes = append(compound.classes, rest[1:end])
+				rule.specificity[1]++
+			}
+			rest = rest[end:]
+		}
+		if len(rule.selector) > 0 {
+			combinator := byte(' ')
+			if child {
+				combinator = '>'
+			}
+			rule.combinators = append(rule.combinators, combinator)
+		}
+		rule.selector = append(rule.selector, compound)
+		child = false
+	}
+	return rule, len(rule.selector) > 0 && !child
+}
+
+func (c *cssCompound) matches(e cssElement) bool {
+	if c.tag != "" && c.tag != "*" && c.tag != e.tag {
+		return false
+	}
+	if c.id != "" && c.id != e.id {
+		return false
+	}
+	for _, class := range c.classes {
+		if !slices.Contains(e.classes, class) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the rule selects element inside ancestors, outermost first.
+func (r *cssRule) matches(element cssElement, ancestors []cssElement) bool {
+	last := len(r.selector) - 1
+	return r.selector[last].matches(element) && matchAncestors(r.selector[:last], r.combinators, ancestors)
+}
+
+// matchAncestors reports whether compounds select a chain of ancestors,
+// combinators[i] being the combinator to the right of compounds[i].
+func matchAncestors(compounds []cssCompound, combinators []byte, ancestors []cssElement) bool {
+	if len(compounds) == 0 {
+		return true
+	}
+	last := len(compounds) - 1
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if compounds[last].matches(ancestors[i]) && matchAncestors(compounds[:last], combinators, ancestors[:i]) {
+			return true
+		}
+		if combinators[last] == '>' {
+			return false // Only the parent may match
+		}
+	}
+	return false
+}
+
+// tagAttribute is an attribute of a start tag, with where it is in the tag.
+type tagAttribute struct {
+	name       string
+	value      string
+	start, end int
+	quote      byte // 0 when unquoted or without a value
+}
+
+// rewriteStartTag adds the declarations of the rules selecting a start tag to
+// its style attribute. open is the stack of elements the tag is in, which it
+// updates for the elements the tag implicitly closes.
+func rewriteStartTag(tag string, rules []cssRule, open *[]cssElement) (string, cssElement, bool) {
+	name, _ := tagName(tag[1:])
+	element := cssElement{tag: name}
+	attrs, selfClosing := tagAttributes(tag, 1+len(name))
+	var style *tagAttribute
+	for i, attr := range attrs {
+		switch {
+		case strings.Contains(attr.value, "{{"):
+			// Only known once sent; classes and IDs without actions still count
+			if attr.name == "class" {
+				for class := range strings.FieldsSeq(attr.value) {
+					if !strings.Contains(class, "{{") && !strings.Contains(class, "}}") {
+						element.classes = append(element.classes, class)
+					}
+				}
+			}
+		case attr.name == "id":
+			element.id = attr.value
+		case attr.name == "class":
+			element.classes = strings.Fields(attr.value)
+		}
+		if attr.name == "style" && style == nil {
+			style = &attrs[i]
+		}
+	}
+	*open = closeImpliedElements(*open, name)
+
+	var decls []cssDecl
+	seen := make(map[string]int) // Index of each property in decls
+	for i := range rules {
+		if !rules[i].matches(element, *open) {
+			continue
+		}
+		for _, decl := range rules[i].decls {
+			j, ok := seen[decl.property]
+			switch {
+			case !ok:
+				seen[decl.property] = len(decls)
+				decls = append(decls, decl)
+			case decl.important || !decls[j].important:
+				decls[j] = decl // A later rule wins, except a normal declaration over an !important one
+			}
+		}
+	}
+	if len(decls) == 0 {
+		return tag, element, selfClosing
+	}
+
+	quote := byte('"')
+	if style != nil && style.quote != 0 {
+		quote = style.quote
+	}
+	other := map[byte]string{'"': "'", '\'': `"`}[quote]
+	var normal, important []string
+	for _, decl := range decls {
+		text := decl.property + ":" + strings.ReplaceAll(decl.value, string(quote), other)
+		if decl.important {
+			important = append(important, text)
+		} else {
+			normal = append(normal, text)
+		}
+	}
+	parts := []string{strings.Join(normal, ";")}
+	if style != nil {
+		parts = append(parts, strings.TrimRight(strings.TrimSpace(style.value), ";"))
+	}
+	parts = append(parts, important...)
+	parts = slices.DeleteFunc(parts, func(s string) bool { return s == "" })
+	attr := "style=" + string(quote) + strings.Join(parts, ";") + string(quote)
+
+	if style != nil {
+		return tag[:style.start] + attr + tag[style.end:], element, selfClosing
+	}
+	end := len(tag)
+	if strings.HasSuffix(tag, "/>") {
+		end -= 2
+	} else if strings.HasSuffix(tag, ">") {
+		end--
+	}
+	before := strings.TrimRight(tag[:end], " \t\r\n")
+	return before + " " + attr + tag[len(before):], element, selfClosing
+}
+
+// tagAttributes reads the attributes of a start tag from offset, after its name.
+func tagAttributes(tag string, offset int) (attrs []tagAttribute, selfClosing bool) {
+	isSpace := func(c byte) bool { return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' }
+	i := offset
+	for i < len(tag) {
+		switch {
+		case isSpace(tag[i]):
+			i++
+			continue
+		case tag[i] == '>':
+			return attrs, false
+		case strings.HasPrefix(tag[i:], "/>"):
+			return attrs, true
+		case tag[i] == '/':
+			i++
+			continue
+		case strings.HasPrefix(tag[i:], "{{"):
+			i += lengthPast(tag[i:], "}}") // An action choosing attributes, such as {{if .X}}checked{{end}}
+			continue
+		}
+
+		attr := tagAttribute{start: i}
+		for i < len(tag) && !isSpace(tag[i]) && !strings.ContainsRune("=>/", rune(tag[i])) && !strings.HasPrefix(tag[i:], "{{") {
+			i++
+		}
+		if i == attr.start {
+			i++ // A stray character
+			continue
+		}
+		attr.name = strings.ToLower(tag[attr.start:i])
+		j := i
+		for j < len(tag) && isSpace(tag[j]) {
+			j++
+		}
+		if j < len(tag) && tag[j] == '=' {
+			i = j + 1
+			for i < len(tag) && isSpace(tag[i]) {
+				i++
+			}
+			valueStart := i
+			if i < len(tag) && (tag[i] == '"' || tag[i] == '\'') {
+				attr.quote = tag[i]
+				i++
+				valueStart = i
+				for i < len(tag) && tag[i] != attr.quote {
+					if strings.HasPrefix(tag[i:], "{{") {
+						i += lengthPast(tag[i:], "}}")
+						continue
+					}
+					i++
+				}
+				attr.value = tag[valueStart:min(i, len(tag))]
+				i = min(i+1, len(tag))
+			} else {
+				for i < len(tag) && !isSpace(tag[i]) && tag[i] != '>' {
+					if strings.HasPrefix(tag[i:], "{{") {
+						i += lengthPast(tag[i:], "}}")
+						continue
+					}
+					i++
+				}
+				attr.value = tag[valueStart:i]
+			}
+		}
+		attr.end = i
+		attrs = append(attrs, attr)
+	}
+	return attrs, false
+}
+
+// closeImpliedElements pops the elements a start tag named name closes
+// without an end tag, as closeImplied does for the text version's tree.
+func closeImpliedElements(open []cssElement, name string) []cssElement {
+	closes := func(tag string, stops ...string) {
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i].tag == tag {
+				open = open[:i]
+				return
+			}
+			if slices.Contains(stops, open[i].tag) {
+				return
+			}
+		}
+	}
+	switch name {
+	case "li":
+		closes("li", "ul", "ol")
+	case "dt", "dd":
+		closes("dt", "dl")
+		closes("dd", "dl")
+	case "td", "th":
+		closes("td", "tr", "table")
+		closes("th", "tr", "table")
+	case "tr":
+		closes("tr", "table")
+	}
+	if blockElements[name] && len(open) > 0 && open[len(open)-1].tag == "p" {
+		open = open[:len(open)-1]
+	}
+	return open
+}
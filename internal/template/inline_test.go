@@ -0,0 +1,87 @@
+package template
+
+import "testing"
+
+func TestInlineCSS(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "Without a style block",
+			source: `<p class="x">{{.Name}}</p>`,
+			want:   `<p class="x">{{.Name}}</p>`,
+		},
+		{
+			name:   "Classes, elements and IDs",
+			source: "<head>\n  <style>\n    p { margin: 0 }\n    .note { color: #666; }\n    #top { font-size: 20px }\n  </style>\n</head>\n<p class=\"note\" id=\"top\">a</p><p>b</p>",
+			want:   "<head>\n</head>\n<p class=\"note\" id=\"top\" style=\"margin:0;color:#666;font-size:20px\">a</p><p style=\"margin:0\">b</p>",
+		},
+		{
+			name:   "Specificity, then order",
+			source: `<style>.a.b { color: red } p.a { color: blue } .a { color: green } p { color: black }</style><p class="a b">x</p>`,
+			want:   `<p class="a b" style="color:red">x</p>`,
+		},
+		{
+			name:   "Own style wins unless important",
+			source: `<style>p { color: red; margin: 0 !important } </style><p style="color: blue; margin: 4px;">x</p>`,
+			want:   `<p style="color:red;color: blue; margin: 4px;margin:0">x</p>`,
+		},
+		{
+			name:   "Descendants and children",
+			source: `<style>.card td { padding: 8px } .card > tr > td { color: red } ul li { margin: 0 }</style><table class="card"><tr><td>a<td>b</tr></table><ul><li>one<li>two</ul>`,
+			want:   `<table class="card"><tr><td style="padding:8px;color:red">a<td style="padding:8px;color:red">b</tr></table><ul><li style="margin:0">one<li style="margin:0">two</ul>`,
+		},
+		{
+			name:   "Rules that cannot be inlined stay",
+			source: "<style>\n  a { color: blue }\n  a:hover { color: red }\n  @media (max-width: 600px) { .sheet { width: 100% } }\n</style><a href=\"/\">x</a>",
+			want:   "<style>\n  a:hover {color: red}\n  @media (max-width: 600px) { .sheet { width: 100% } }\n</style><a href=\"/\" style=\"color:blue\">x</a>",
+		},
+		{
+			name:   "Template actions",
+			source: `<style>.big { font-family: "Segoe UI", sans-serif } img { border: 0 }</style>{{if .A}}<a href="{{.URL | printf "%s>"}}" class="big {{.Class}}">x</a>{{end}}<img src="{{.Logo}}" alt="{{"<logo>"}}"/>`,
+			want:   `{{if .A}}<a href="{{.URL | printf "%s>"}}" class="big {{.Class}}" style="font-family:'Segoe UI', sans-serif">x</a>{{end}}<img src="{{.Logo}}" alt="{{"<logo>"}}" style="border:0"/>`,
+		},
+		{
+			name:   "Blocks with template actions are left alone",
+			source: `<style>p { color: {{.Color}} }</style><p>x</p>`,
+			want:   `<style>p { color: {{.Color}} }</style><p>x</p>`,
+		},
+		{
+			name:   "Nested tables keep their ancestors",
+			source: `<style>.wrap td { padding: 8px }</style><table class="wrap"><tr><td><table><tr><td>inner</td></tr></table></td><td>outer</td></tr></table>`,
+			want:   `<table class="wrap"><tr><td style="padding:8px"><table><tr><td style="padding:8px">inner</td></tr></table></td><td style="padding:8px">outer</td></tr></table>`,
+		},
+		{
+			name:   "Nested elements of the same name",
+			source: `<style>.wrap p { margin: 0 }</style><div class="wrap"><div>x</div><p>after</p></div>`,
+			want:   `<div class="wrap"><div>x</div><p style="margin:0">after</p></div>`,
+		},
+		{
+			name:   "Comments, raw text and data URLs",
+			source: `<style>/* p { color: red } */ b { background: url("data:image/png;base64,AA==") }</style><!-- <b>no</b> --><title><b>no</b></title><b>yes</b>`,
+			want:   `<!-- <b>no</b> --><title><b>no</b></title><b style="background:url('data:image/png;base64,AA==')">yes</b>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := InlineCSS(tt.source); got != tt.want {
+				t.Errorf("InlineCSS() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuiltinTemplatesAreInlined(t *testing.T) {
+	for _, name := range []string{"auto-reply", "card", "message"} {
+		message, err := Render(name, nil, sampleContact)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if indexFold(message.HTML, "<style") >= 0 || indexFold(message.HTML, `style="`) < 0 {
+			t.Errorf("Render(%q) should carry its styles inline:\n%s", name, message.HTML)
+		}
+	}
+}
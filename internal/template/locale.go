@@ -0,0 +1,225 @@
+package template
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLocale is the locale every fallback chain ends with. Its catalog has every label.
+const DefaultLocale = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// localeTag is what a locale may be called: a language, optionally followed by
+// a region or script, lower-cased with hyphens such as "de" or "pt-br".
+var localeTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// catalog is one file in locales/: the labels templates show as {{.T.<key>}}
+// and how dates are written. Days start on Sunday, as time.Weekday does.
+type catalog struct {
+	DateFormat string            `json:"date_format"` // A time.Format layout; English names are replaced by Days and Months
+	Days       []string          `json:"days"`
+	Months     []string          `json:"months"`
+	Labels     map[string]string `json:"labels"`
+}
+
+// catalogs holds the embedded catalogs by tag, parsed once at startup.
+var catalogs = mustParseCatalogs()
+
+func mustParseCatalogs() map[string]*catalog {
+	entries, err := fs.ReadDir(localeFiles, "locales")
+	if err != nil {
+		panic(err)
+	}
+	parsed := make(map[string]*catalog, len(entries))
+	for _, entry := range entries {
+		tag := strings.TrimSuffix(entry.Name(), ".json")
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var c catalog
+		if err := json.Unmarshal(data, &c); err != nil {
+			panic(fmt.Sprintf("locale %q: %v", tag, err)) // Embedded catalogs are fixed at build time
+		}
+		if !localeTag.MatchString(tag) || (c.Days != nil && len(c.Days) != 7) || (c.Months != nil && len(c.Months) != 12) {
+			panic(fmt.Sprintf("locale %q: invalid tag, days or months", tag))
+		}
+		parsed[tag] = &c
+	}
+	if parsed[DefaultLocale] == nil {
+		panic("locale " + DefaultLocale + " is missing")
+	}
+	return parsed
+}
+
+// Locale is the language a message is rendered in, resolved from the
+// preferences of whoever reads it.
+type Locale struct {
+	Tag    string            // The most preferred locale with a catalog, such as "de"
+	Labels map[string]string // Labels of every catalog in the chain, the most preferred winning
+	chain  []string          // Every tag to look for template variants with, most preferred first
+	dates  *catalog
+}
+
+// ResolveLocale builds a Locale from preferences, most preferred first, such
+// as a locale field followed by the tags of an Accept-Language header. Each
+// tag falls back to its language, so "de-AT" is followed by "de", and the
+// chain always ends with DefaultLocale. Empty and malformed tags are skipped.
+func ResolveLocale(preferences ...string) *Locale {
+	var chain []string
+	for _, preference := range append(preferences, DefaultLocale) {
+		tag := NormalizeLocale(preference)
+		if !localeTag.MatchString(tag) {
+			continue
+		}
+		for {
+			if !slices.Contains(chain, tag) {
+				chain = append(chain, tag)
+			}
+			i := strings.LastIndexByte(tag, '-')
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+
+	locale := &Locale{chain: chain, Labels: make(map[string]string)}
+	for i := len(chain) - 1; i >= 0; i-- {
+		c := catalogs[chain[i]]
+		if c == nil {
+			continue
+		}
+		for key, label := range c.Labels {
+			locale.Labels[key] = label
+		}
+		locale.Tag, locale.dates = chain[i], c
+	}
+	return locale
+}
+
+// NormalizeLocale lower-cases tag and writes it with hyphens, so "pt_BR" becomes "pt-br".
+func NormalizeLocale(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+// CheckLocale returns an error when tag is set to something that is not a locale tag.
+func CheckLocale(tag string) error {
+	if tag == "" || localeTag.MatchString(NormalizeLocale(tag)) {
+		return nil
+	}
+	return fmt.Errorf("invalid locale %q, use a language tag such as %q or %q", tag, "de", "pt-BR")
+}
+
+// Locales returns the sorted tags that have a catalog.
+func Locales() []string {
+	tags := make([]string, 0, len(catalogs))
+	for tag := range catalogs {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// ParseAcceptLanguage returns the tags of an Accept-Language header, most
+// preferred first. Tags with a weight of zero and the "*" wildcard are left out.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for part := range strings.SplitSeq(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.TrimSpace(tag)
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				continue
+			}
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+	slices.SortStableFunc(tags, func(a, b weighted) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+
+	preferences := make([]string, len(tags))
+	for i, t := range tags {
+		preferences[i] = t.tag
+	}
+	return preferences
+}
+
+// FormatTime writes t the way the locale writes dates, with its own day and month names.
+func (l *Locale) FormatTime(t time.Time) string {
+	return l.format(l.catalog().DateFormat, t)
+}
+
+// catalog returns the catalog the locale writes dates with.
+func (l *Locale) catalog() *catalog {
+	if l == nil || l.dates == nil {
+		return catalogs[DefaultLocale]
+	}
+	return l.dates
+}
+
+// labels returns the locale's labels, or those of DefaultLocale for a nil locale.
+func (l *Locale) labels() map[string]string {
+	if l == nil {
+		return catalogs[DefaultLocale].Labels
+	}
+	return l.Labels
+}
+
+// format writes t with a time.Format layout, using the locale's day and month names.
+func (l *Locale) format(layout string, t time.Time) string {
+	c := l.catalog()
+	// time.Format only knows English names, so the layout gets placeholders
+	// for them that are filled in afterwards. The placeholders are letters
+	// time.Format copies as they are; the long names go first so "Monday"
+	// is not taken for "Mon".
+	var names []string
+	if len(c.Days) == 7 {
+		day := c.Days[t.Weekday()]
+		names = append(names, "Monday", day, "Mon", abbreviate(day))
+	}
+	if len(c.Months) == 12 {
+		month := c.Months[t.Month()-1]
+		names = append(names, "January", month, "Jan", abbreviate(month))
+	}
+
+	var fill []string
+	for i := 0; i < len(names); i += 2 {
+		placeholder := "\x00" + string(rune('w'+i/2)) + "\x00"
+		layout = strings.ReplaceAll(layout, names[i], placeholder)
+		fill = append(fill, placeholder, names[i+1])
+	}
+	return strings.NewReplacer(fill...).Replace(t.Format(layout))
+}
+
+// abbreviate shortens a day or month name to its first three letters.
+func abbreviate(name string) string {
+	runes := []rune(name)
+	return string(runes[:min(3, len(runes))])
+}
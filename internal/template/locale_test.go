@@ -0,0 +1,108 @@
+package template
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveLocale(t *testing.T) {
+	tests := []struct {
+		preferences []string
+		wantTag     string
+		wantChain   []string
+	}{
+		{nil, "en", []string{"en"}},
+		{[]string{"de-AT", "fr"}, "de", []string{"de-at", "de", "fr", "en"}},
+		{[]string{"", "pt_BR", "not a tag", "es"}, "es", []string{"pt-br", "pt", "es", "en"}},
+		{[]string{"EN-gb"}, "en", []string{"en-gb", "en"}},
+	}
+	for _, tt := range tests {
+		locale := ResolveLocale(tt.preferences...)
+		if locale.Tag != tt.wantTag || !slices.Equal(locale.chain, tt.wantChain) {
+			t.Errorf("ResolveLocale(%q) = %q %v, want %q %v", tt.preferences, locale.Tag, locale.chain, tt.wantTag, tt.wantChain)
+		}
+	}
+
+	if got := ResolveLocale("de").Labels["message"]; got != "Nachricht" {
+		t.Errorf("German message label = %q", got)
+	}
+}
+
+func TestCatalogsOnlyTranslateKnownLabels(t *testing.T) {
+	for tag, c := range catalogs {
+		for key := range c.Labels {
+			if _, ok := catalogs[DefaultLocale].Labels[key]; !ok {
+				t.Errorf("locale %q has label %q, which %q lacks", tag, key, DefaultLocale)
+			}
+		}
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	got := ParseAcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8, de;q=0.95, *;q=0.5, it;q=0, es;q=oops")
+	want := []string{"fr-CH", "de", "fr", "en"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseAcceptLanguage() = %q, want %q", got, want)
+	}
+	if got := ParseAcceptLanguage(""); len(got) != 0 {
+		t.Errorf("ParseAcceptLanguage(\"\") = %q", got)
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	at := time.Date(2025, time.March, 3, 9, 5, 0, 0, time.UTC)
+	tests := map[string]string{
+		"en": "Monday, 03 Mar 2025 09:05",
+		"de": "Montag, 03. März 2025 09:05",
+		"fr": "lundi 03 mars 2025 09:05",
+		"es": "lunes, 03 de marzo de 2025 09:05",
+	}
+	for tag, want := range tests {
+		if got := ResolveLocale(tag).FormatTime(at); got != want {
+			t.Errorf("FormatTime(%s) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestRenderLocalized(t *testing.T) {
+	form := &model.ContactForm{Name: "Ada", Email: "ada@example.com", Subject: "Hi", Message: "Hello"}
+	locale := ResolveLocale("de-DE")
+	message, err := Render("auto-reply", locale, NewContactData(form, locale, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Subject != "Wir haben Ihre Nachricht erhalten: Hi" {
+		t.Errorf("Subject = %q", message.Subject)
+	}
+	if !strings.Contains(message.HTML, `lang="de"`) || !strings.Contains(message.HTML, "Hallo Ada") {
+		t.Errorf("Expected a German message:\n%s", message.HTML)
+	}
+
+	dir := t.TempDir()
+	t.Cleanup(func() { _ = LoadDir("") })
+	writeTemplateFile(t, dir, "brand.html", "<p>Hello</p>")
+	writeTemplateFile(t, dir, "brand.de.html", "<p>Hallo</p>")
+	writeTemplateFile(t, dir, "brand.fr-ca.html", "<p>Allô</p>")
+	if err := LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if names := Names(); slices.Contains(names, "brand.de") || !slices.Contains(names, "brand") {
+		t.Errorf("Names() = %v, want variants left out", names)
+	}
+	if err := Check("brand.de"); err == nil {
+		t.Error("Variants should not be chosen by name")
+	}
+
+	for preference, want := range map[string]string{"de-CH": "<p>Hallo</p>", "fr-CA": "<p>Allô</p>", "fr": "<p>Hello</p>", "": "<p>Hello</p>"} {
+		message, err := Render("brand", ResolveLocale(preference), sampleContact)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.HTML != want {
+			t.Errorf("Render(brand, %q) = %q, want %q", preference, message.HTML, want)
+		}
+	}
+}
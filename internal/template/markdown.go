@@ -0,0 +1,746 @@
+package template
+
+import (
+	"bytes"
+	"html"
+	htmltemplate "html/template"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Markdown renders a CommonMark subset to HTML: ATX and setext headings,
+// paragraphs, bullet and ordered lists, block quotes, rules, fenced and
+// indented code, and inline emphasis, code spans, links, images and hard
+// breaks. Raw HTML, blocks and inline tags alike, is stripped, and links only
+// keep http, https and mailto URLs, so the result is safe to put in an email.
+func Markdown(source string) htmltemplate.HTML {
+	return markdown{links: true}.render(source)
+}
+
+// VisitorMarkdown renders Markdown written by visitors like Markdown, except
+// that links and images are written as text, so a contact message cannot
+// carry links to click or pixels that load when it is opened.
+func VisitorMarkdown(source string) htmltemplate.HTML {
+	return markdown{}.render(source)
+}
+
+// markdown renders Markdown, with links and images or without.
+type markdown struct {
+	links bool
+	depth int // How deeply the inline text being rendered is nested in emphasis, links and images
+}
+
+// maxInlineNesting bounds how deeply emphasis, links and images nest. Deeper
+// ones are written as text, so each byte is rendered a bounded number of times.
+const maxInlineNesting = 16
+
+// maxLinkParens bounds the nesting of parentheses in a link destination.
+const maxLinkParens = 32
+
+// nested returns the renderer for inline text nested in emphasis, a link or an image.
+func (r markdown) nested() markdown {
+	r.depth++
+	return r
+}
+
+func (r markdown) render(source string) htmltemplate.HTML {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\t", "    ")
+	var out strings.Builder
+	r.renderMarkdownBlocks(&out, strings.Split(source, "\n"), false)
+	return htmltemplate.HTML(strings.TrimSuffix(out.String(), "\n"))
+}
+
+var (
+	atxHeading   = regexp.MustCompile(`^(#{1,6})(?:[ ]+(.*?))?(?:[ ]+#+)?[ ]*$`)
+	thematicRule = regexp.MustCompile(`^(?:(?:\*[ ]*){3,}|(?:-[ ]*){3,}|(?:_[ ]*){3,})$`)
+	setextLine   = regexp.MustCompile(`^(?:=+|-+)[ ]*$`)
+	bulletItem   = regexp.MustCompile(`^([-*+])(?:[ ]+|$)`)
+	orderedItem  = regexp.MustCompile(`^([0-9]{1,9})([.)])(?:[ ]+|$)`)
+	rawHTMLBlock = regexp.MustCompile(`^<(script|pre|style|textarea)(?:[ >]|$)`)
+	htmlBlock    = regexp.MustCompile(`^(?:<!--|</?(?:address|article|aside|blockquote|body|center|dd|details|div|dl|dt|` +
+		`fieldset|figcaption|figure|footer|form|h[1-6]|head|header|hr|html|iframe|li|link|main|meta|nav|ol|p|` +
+		`section|table|tbody|td|tfoot|th|thead|title|tr|ul)(?:[ />]|$)|</?[A-Za-z][A-Za-z0-9-]*(?:\s[^<>]*)?/?>[ ]*$)`)
+	entityRef = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	inlineTag = regexp.MustCompile(`^<(?:/?[A-Za-z][A-Za-z0-9-]*(?:\s[^<>]*)?/?|!--[\s\S]*?--)>`)
+	autolink  = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^\s<>]*|[^\s<>@]+@[^\s<>@]+\.[^\s<>@]+)>`)
+)
+
+// splitIndent returns a line without its leading spaces, and how many there were.
+func splitIndent(line string) (string, int) {
+	trimmed := strings.TrimLeft(line, " ")
+	return trimmed, len(line) - len(trimmed)
+}
+
+// codeFence returns the fence opening a code block, such as "```", if line is one.
+func codeFence(line string) string {
+	for _, c := range []string{"`", "~"} {
+		n := len(line) - len(strings.TrimLeft(line, c))
+		if n >= 3 && (c == "~" || !strings.Contains(line[n:], "`")) {
+			return line[:n]
+		}
+	}
+	return ""
+}
+
+// listItem describes a list marker at the start of a line.
+type listItem struct {
+	ordered bool
+	start   int
+	kind    string // Bullet character, or the delimiter after the number
+	content int    // Column where the item's content starts
+}
+
+func parseListItem(line string) (listItem, bool) {
+	trimmed, indent := splitIndent(line)
+	if indent >= 4 {
+		return listItem{}, false
+	}
+	if m := bulletItem.FindStringSubmatch(trimmed); m != nil && !thematicRule.MatchString(trimmed) {
+		return listItem{kind: m[1], content: indent + markerWidth(len(m[1]), len(m[0]), trimmed)}, true
+	}
+	if m := orderedItem.FindStringSubmatch(trimmed); m != nil {
+		start, _ := strconv.Atoi(m[1])
+		return listItem{ordered: true, start: start, kind: m[2], content: indent + markerWidth(len(m[1])+1, len(m[0]), trimmed)}, true
+	}
+	return listItem{}, false
+}
+
+// markerWidth is how far an item's content is from its marker. Content
+// indented by more than four spaces is indented code, which counts from one.
+func markerWidth(marker, matched int, line string) int {
+	spaces := matched - marker
+	if spaces > 4 || matched == len(line) {
+		return marker + 1
+	}
+	return matched
+}
+
+// interruptsParagraph reports whether line starts a block that ends a paragraph.
+func interruptsParagraph(line string) bool {
+	trimmed, indent := splitIndent(line)
+	if indent >= 4 {
+		return false
+	}
+	if item, ok := parseListItem(line); ok {
+		// Only lists that cannot be a number at the start of a sentence
+		rest := strings.TrimSpace(trimmed[min(item.content-indent, len(trimmed)):]) // An empty item ends its line
+		return rest != "" && (!item.ordered || item.start == 1)
+	}
+	return atxHeading.MatchString(trimmed) || thematicRule.MatchString(trimmed) || codeFence(trimmed) != "" ||
+		strings.HasPrefix(trimmed, ">") || rawHTMLBlock.MatchString(trimmed) || htmlBlock.MatchString(trimmed)
+}
+
+// renderMarkdownBlocks renders lines as block elements. In tight lists the
+// paragraphs of an item are written without <p>.
+func (r markdown) renderMarkdownBlocks(out *strings.Builder, lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		trimmed, indent := splitIndent(lines[i])
+		switch {
+		case trimmed == "":
+			i++
+
+		case indent >= 4:
+			var code []string
+			for ; i < len(lines); i++ {
+				rest, n := splitIndent(lines[i])
+				if rest != "" && n < 4 {
+					break
+				}
+				code = append(code, strings.TrimPrefix(lines[i], "    "))
+			}
+			for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+				code = code[:len(code)-1]
+			}
+			out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "\n</code></pre>\n")
+
+		case codeFence(trimmed) != "":
+			fence := codeFence(trimmed)
+			var code []string
+			for i++; i < len(lines); i++ {
+				rest, _ := splitIndent(lines[i])
+				if strings.HasPrefix(rest, fence) && strings.Trim(rest, fence[:1]+" ") == "" {
+					i++
+					break
+				}
+				line := lines[i]
+				for n := 0; n < indent && strings.HasPrefix(line, " "); n++ {
+					line = line[1:]
+				}
+				code = append(code, line)
+			}
+			body := html.EscapeString(strings.Join(code, "\n"))
+			if len(code) > 0 {
+				body += "\n"
+			}
+			out.WriteString("<pre><code>" + body + "</code></pre>\n")
+
+		case atxHeading.MatchString(trimmed):
+			m := atxHeading.FindStringSubmatch(trimmed)
+			level := strconv.Itoa(len(m[1]))
+			out.WriteString("<h" + level + ">" + r.renderInline(m[2]) + "</h" + level + ">\n")
+			i++
+
+		case thematicRule.MatchString(trimmed):
+			out.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			var quoted []string
+			for ; i < len(lines); i++ {
+				rest, _ := splitIndent(lines[i])
+				if rest == "" {
+					break
+				}
+				if strings.HasPrefix(rest, ">") {
+					rest = strings.TrimPrefix(rest[1:], " ")
+				} else if len(quoted) > 0 && interruptsParagraph(lines[i]) {
+					break
+				}
+				quoted = append(quoted, rest)
+			}
+			out.WriteString("<blockquote>\n")
+			r.renderMarkdownBlocks(out, quoted, false)
+			out.WriteString("</blockquote>\n")
+
+		case rawHTMLBlock.MatchString(trimmed):
+			// Dropped up to its end tag, blank lines included
+			end := "</" + rawHTMLBlock.FindStringSubmatch(trimmed)[1] + ">"
+			for ; i < len(lines); i++ {
+				if strings.Contains(strings.ToLower(lines[i]), end) {
+					i++
+					break
+				}
+			}
+
+		case htmlBlock.MatchString(trimmed):
+			// Raw HTML is dropped, up to the blank line that ends it
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+				i++
+			}
+
+		default:
+			if item, ok := parseListItem(lines[i]); ok {
+				i = r.renderList(out, lines, i, item)
+				continue
+			}
+
+			paragraph := []string{trimmed}
+			level := ""
+			for i++; i < len(lines); i++ {
+				rest, n := splitIndent(lines[i])
+				if rest == "" {
+					break
+				}
+				if n < 4 && setextLine.MatchString(rest) {
+					level = map[byte]string{'=': "1", '-': "2"}[rest[0]]
+					i++
+					break
+				}
+				if interruptsParagraph(lines[i]) {
+					break
+				}
+				paragraph = append(paragraph, rest)
+			}
+			text := r.renderInline(strings.Join(paragraph, "\n"))
+			switch {
+			case level != "":
+				out.WriteString("<h" + level + ">" + text + "</h" + level + ">\n")
+			case tight:
+				out.WriteString(text + "\n")
+			default:
+				out.WriteString("<p>" + text + "</p>\n")
+			}
+		}
+	}
+}
+
+// renderList renders the list starting at lines[i] and returns the index of
+// the first line after it.
+func (r markdown) renderList(out *strings.Builder, lines []string, i int, first listItem) int {
+	var items [][]string
+	loose := false
+	blankBefore := false // The last line was blank
+	for i < len(lines) {
+		item, ok := parseListItem(lines[i])
+		if !ok || item.ordered != first.ordered || item.kind != first.kind {
+			break
+		}
+		if len(items) > 0 && blankBefore {
+			loose = true
+		}
+		content := []string{lines[i][min(item.content, len(lines[i])):]}
+
+		blankBefore = false
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			rest, indent := splitIndent(line)
+			if rest == "" {
+				blankBefore = true
+				content = append(content, "")
+				continue
+			}
+			if indent >= item.content {
+				if blankBefore && len(content) > 1 {
+					loose = true // Blocks of one item apart
+				}
+				content = append(content, line[item.content:])
+				blankBefore = false
+				continue
+			}
+			if _, marker := parseListItem(line); marker {
+				break // The next item, or another list
+			}
+			if !blankBefore && !interruptsParagraph(line) {
+				content = append(content, rest) // Lazy continuation of the item's paragraph
+				continue
+			}
+			break
+		}
+		items = append(items, content)
+	}
+	if last := len(items) - 1; last >= 0 {
+		// Blank lines after the last item end the list; they do not loosen it
+		for len(items[last]) > 1 && items[last][len(items[last])-1] == "" {
+			items[last] = items[last][:len(items[last])-1]
+		}
+	}
+
+	tag := "ul"
+	open := "<ul>\n"
+	if first.ordered {
+		tag = "ol"
+		open = "<ol>\n"
+		if first.start != 1 {
+			open = `<ol start="` + strconv.Itoa(first.start) + `">` + "\n"
+		}
+	}
+	out.WriteString(open)
+	for _, content := range items {
+		var item strings.Builder
+		r.renderMarkdownBlocks(&item, content, !loose)
+		out.WriteString("<li>" + strings.TrimSuffix(item.String(), "\n") + "</li>\n")
+	}
+	out.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// renderInline renders the inline content of a block.
+func (r markdown) renderInline(text string) string {
+	var out bytes.Buffer
+	t := &inlineText{text: text}
+	nest := r.depth < maxInlineNesting
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+			out.WriteString("<br>\n")
+			i += 2
+
+		case c == '\\' && i+1 < len(text) && isASCIIPunct(text[i+1]):
+			out.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+
+		case c == '`':
+			run := len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+			end := closingBackticks(text[i+run:], run)
+			if end < 0 {
+				out.WriteString(text[i : i+run])
+				i += run
+				break
+			}
+			code := strings.ReplaceAll(text[i+run:i+run+end], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+			out.WriteString("<code>" + html.EscapeString(code) + "</code>")
+			i += run + end + run
+
+		case c == '!' && strings.HasPrefix(text[i+1:], "[") && nest:
+			if label, dest, n, ok := t.parseLink(i + 1); ok {
+				if !r.links {
+					out.WriteString(html.EscapeString(r.nested().plainText(label))) // The alt text, and no image to load
+				} else if src := safeURL(dest, false); src != "" {
+					out.WriteString(`<img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(r.nested().plainText(label)) + `">`)
+				} else {
+					out.WriteString(r.nested().renderInline(label))
+				}
+				i += 1 + n
+				break
+			}
+			out.WriteByte('!')
+			i++
+
+		case c == '[' && nest:
+			if label, dest, n, ok := t.parseLink(i); ok {
+				if href := safeURL(dest, true); href != "" && r.links {
+					out.WriteString(`<a href="` + html.EscapeString(href) + `">` + r.nested().renderInline(label) + "</a>")
+				} else {
+					out.WriteString(r.nested().renderInline(label))
+				}
+				i += n
+				break
+			}
+			out.WriteByte('[')
+			i++
+
+		case c == '<':
+			if m := autolink.FindStringSubmatch(text[i:]); m != nil {
+				href := m[1]
+				if strings.Contains(href, "@") && !strings.Contains(href, ":") {
+					href = "mailto:" + href
+				}
+				if href = safeURL(href, true); href != "" && r.links {
+					out.WriteString(`<a href="` + html.EscapeString(href) + `">` + html.EscapeString(m[1]) + "</a>")
+				} else {
+					out.WriteString(html.EscapeString(m[1]))
+				}
+				i += len(m[0])
+				break
+			}
+			if m := inlineTag.FindString(text[i:]); m != "" {
+				i += len(m) // Raw HTML is dropped
+				break
+			}
+			out.WriteString("&lt;")
+			i++
+
+		case (c == '*' || c == '_') && nest:
+			if html, n, ok := r.renderEmphasis(t, i); ok {
+				out.WriteString(html)
+				i += n
+				break
+			}
+			run := len(text[i:]) - len(strings.TrimLeft(text[i:], string(c)))
+			out.WriteString(text[i : i+run])
+			i += run
+
+		case c == '&':
+			if m := entityRef.FindString(text[i:]); m != "" {
+				out.WriteString(m) // Already escaped, as in mail-merge values
+				i += len(m)
+				break
+			}
+			out.WriteString("&amp;")
+			i++
+
+		case c == '\n':
+			// Trailing spaces are dropped, and two or more make a hard break
+			trimmed := len(bytes.TrimRight(out.Bytes(), " "))
+			spaces := out.Len() - trimmed
+			out.Truncate(trimmed)
+			if spaces >= 2 {
+				out.WriteString("<br>")
+			}
+			out.WriteByte('\n')
+			i++
+
+		case c == '!' || c == '[' || c == '*' || c == '_':
+			out.WriteString(html.EscapeString(text[i : i+1])) // Nested too deeply to render
+			i++
+
+		default:
+			next := strings.IndexAny(text[i+1:], "\\`![<*_&\n")
+			if next < 0 {
+				next = len(text) - i - 1
+			}
+			out.WriteString(html.EscapeString(text[i : i+1+next]))
+			i += 1 + next
+		}
+	}
+	return out.String()
+}
+
+// closingBackticks returns where a run of exactly n backticks starts in s, or -1.
+func closingBackticks(s string, n int) int {
+	for i := 0; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		run := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+// inlineText is the text of one inline rendering with indexes of where its
+// delimiters close. An unclosed bracket or emphasis would otherwise be searched
+// for up to the end of the text again from every opener; the indexes are each
+// built in one pass, so rendering stays linear however the text is written.
+type inlineText struct {
+	text     string
+	brackets map[int]int          // The closing bracket of every opening one, or -1
+	closers  map[closerKind][]int // Where each kind of emphasis may close
+	bytes    map[byte][]int       // Where each byte ending a link title or destination is
+}
+
+// closerKind is a run of n delimiters c closing emphasis.
+type closerKind struct {
+	c byte
+	n int
+}
+
+// closingBracket returns where the bracket opening at text[open] closes, or -1.
+// Escaped brackets and those in code spans do not count.
+func (t *inlineText) closingBracket(open int) int {
+	if t.brackets == nil {
+		t.brackets = make(map[int]int)
+		var opened []int
+		s := t.text
+		for i := 0; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '`':
+				run := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+				if close := closingBackticks(s[i+run:], run); close >= 0 {
+					i += run + close + run - 1
+				} else {
+					i += run - 1
+				}
+			case '[':
+				t.brackets[i] = -1
+				opened = append(opened, i)
+			case ']':
+				if len(opened) > 0 {
+					t.brackets[opened[len(opened)-1]] = i
+					opened = opened[:len(opened)-1]
+				}
+			}
+		}
+	}
+	if close, ok := t.brackets[open]; ok {
+		return close
+	}
+	return -1
+}
+
+// closingEmphasis returns where the first run of exactly n delimiters c that
+// can close emphasis starts at or after from, or -1.
+func (t *inlineText) closingEmphasis(c byte, n, from int) int {
+	kind := closerKind{c, n}
+	if t.closers == nil {
+		t.closers = make(map[closerKind][]int)
+	}
+	positions, ok := t.closers[kind]
+	if !ok {
+		for at := 0; ; {
+			close := closingDelimiter(t.text[at:], c, n)
+			if close < 0 {
+				break
+			}
+			positions = append(positions, at+close)
+			at += close + n
+		}
+		t.closers[kind] = positions
+	}
+	return nextPosition(positions, from)
+}
+
+// indexByte returns where the first b at or after from is, or -1.
+func (t *inlineText) indexByte(b byte, from int) int {
+	if t.bytes == nil {
+		t.bytes = make(map[byte][]int)
+	}
+	positions, ok := t.bytes[b]
+	if !ok {
+		for i := 0; i < len(t.text); i++ {
+			if t.text[i] == b {
+				positions = append(positions, i)
+			}
+		}
+		t.bytes[b] = positions
+	}
+	return nextPosition(positions, from)
+}
+
+// nextPosition returns the first of the sorted positions at or after from, or -1.
+func nextPosition(positions []int, from int) int {
+	if i, _ := slices.BinarySearch(positions, from); i < len(positions) {
+		return positions[i]
+	}
+	return -1
+}
+
+// parseLink reads "[label](destination "title")" at text[start] and returns
+// the label, the destination and the length of the whole link.
+func (t *inlineText) parseLink(start int) (label, dest string, n int, ok bool) {
+	s := t.text
+	end := t.closingBracket(start)
+	if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+		return "", "", 0, false
+	}
+	label = s[start+1 : end]
+
+	i := skipSpaces(s, end+2)
+	if i < len(s) && s[i] == '<' {
+		close := t.indexByte('>', i)
+		if newline := t.indexByte('\n', i); close < 0 || (newline >= 0 && newline < close) {
+			return "", "", 0, false
+		}
+		dest, i = s[i+1:close], close+1
+	} else {
+		from, parens := i, 0
+		for i < len(s) && s[i] != ' ' && s[i] != '\n' && (s[i] != ')' || parens > 0) {
+			switch s[i] {
+			case '\\':
+				i++
+			case '(':
+				if parens++; parens > maxLinkParens {
+					return "", "", 0, false
+				}
+			case ')':
+				parens--
+			}
+			i++
+		}
+		i = min(i, len(s))
+		dest = s[from:i]
+	}
+
+	i = skipSpaces(s, i)
+	if i < len(s) && (s[i] == '"' || s[i] == '\'' || s[i] == '(') {
+		closer := s[i]
+		if closer == '(' {
+			closer = ')'
+		}
+		close := t.indexByte(closer, i+1)
+		if close < 0 {
+			return "", "", 0, false
+		}
+		i = skipSpaces(s, close+1)
+	}
+	if i >= len(s) || s[i] != ')' {
+		return "", "", 0, false
+	}
+	return label, html.UnescapeString(unescapePunct(dest)), i + 1 - start, true
+}
+
+// skipSpaces returns the index of the first byte from i on that is not a space or a line break.
+func skipSpaces(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// unescapePunct removes the backslashes escaping punctuation.
+func unescapePunct(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			i++
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+// safeURL returns u if it may be linked from an email: http and https, and
+// mailto for links, or a URL without a scheme. Anything else yields "".
+func safeURL(u string, link bool) string {
+	u = strings.TrimSpace(u)
+	scheme, _, found := strings.Cut(u, ":")
+	if !found || strings.ContainsAny(scheme, "/?#") {
+		return u
+	}
+	switch strings.ToLower(scheme) {
+	case "http", "https":
+		return u
+	case "mailto":
+		if link {
+			return u
+		}
+	}
+	return ""
+}
+
+// renderEmphasis renders *em*, **strong** or ***both*** opening at t.text[i],
+// returning the HTML and how much of the text it covers.
+func (r markdown) renderEmphasis(t *inlineText, i int) (string, int, bool) {
+	text := t.text
+	c := text[i]
+	run := len(text[i:]) - len(strings.TrimLeft(text[i:], string(c)))
+	after, _ := utf8.DecodeRuneInString(text[i+run:])
+	if i+run >= len(text) || unicode.IsSpace(after) {
+		return "", 0, false // Not left-flanking
+	}
+	if c == '_' && i > 0 {
+		if before, _ := utf8.DecodeLastRuneInString(text[:i]); isWordRune(before) {
+			return "", 0, false // Intraword underscores are literal
+		}
+	}
+
+	for n := min(run, 3); n >= 1; n-- {
+		close := t.closingEmphasis(c, n, i+run)
+		if close < 0 {
+			continue
+		}
+		inner := r.nested().renderInline(text[i+run : close])
+		prefix := strings.Repeat(string(c), run-n) // Delimiters without a partner stay text
+		switch n {
+		case 3:
+			inner = "<em><strong>" + inner + "</strong></em>"
+		case 2:
+			inner = "<strong>" + inner + "</strong>"
+		default:
+			inner = "<em>" + inner + "</em>"
+		}
+		return html.EscapeString(prefix) + inner, close + n - i, true
+	}
+	return "", 0, false
+}
+
+// closingDelimiter returns where a run of exactly n c characters closing an
+// emphasis starts in s, or -1. Code spans are skipped.
+func closingDelimiter(s string, c byte, n int) int {
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '\\':
+			i += 2
+			continue
+		case '`':
+			run := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			if close := closingBackticks(s[i+run:], run); close >= 0 {
+				i += run + close + run
+			} else {
+				i += run
+			}
+			continue
+		case c:
+		default:
+			i++
+			continue
+		}
+		run := len(s[i:]) - len(strings.TrimLeft(s[i:], string(c)))
+		before, _ := utf8.DecodeLastRuneInString(s[:i])
+		after, _ := utf8.DecodeRuneInString(s[i+run:])
+		rightFlanking := i > 0 && !unicode.IsSpace(before)
+		if run == n && rightFlanking && (c != '_' || i+run == len(s) || !isWordRune(after)) {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isASCIIPunct(b byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", b) >= 0
+}
+
+// plainText is the text of inline Markdown, for image descriptions.
+func (r markdown) plainText(markdown string) string {
+	return HTMLToText(r.renderInline(markdown))
+}
@@ -0,0 +1,145 @@
+package template
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "Headings and paragraphs",
+			markdown: "# Title #\n\nFirst line\nsecond line\n\nSetext\n------",
+			want:     "<h1>Title</h1>\n<p>First line\nsecond line</p>\n<h2>Setext</h2>",
+		},
+		{
+			name:     "Emphasis",
+			markdown: "*em* **strong** ***both*** _under_ snake_case_name 2 * 3 * 4",
+			want:     "<p><em>em</em> <strong>strong</strong> <em><strong>both</strong></em> <em>under</em> snake_case_name 2 * 3 * 4</p>",
+		},
+		{
+			name:     "Code",
+			markdown: "Use `a <b>` here\n\n```go\nif a < b {}\n```\n\n    indented",
+			want:     "<p>Use <code>a &lt;b&gt;</code> here</p>\n<pre><code>if a &lt; b {}\n</code></pre>\n<pre><code>indented\n</code></pre>",
+		},
+		{
+			name:     "Tight lists",
+			markdown: "- one\n- two\n  1. nested\n  2. again\n- three",
+			want:     "<ul>\n<li>one</li>\n<li>two\n<ol>\n<li>nested</li>\n<li>again</li>\n</ol></li>\n<li>three</li>\n</ul>",
+		},
+		{
+			name:     "Loose ordered list",
+			markdown: "3. first\n\n4. second",
+			want:     "<ol start=\"3\">\n<li><p>first</p></li>\n<li><p>second</p></li>\n</ol>",
+		},
+		{
+			name:     "Links",
+			markdown: `[docs](https://example.com/a?b=1&c=2 "Title") <https://example.com> <ada@example.com> ![logo](https://example.com/l.png)`,
+			want: `<p><a href="https://example.com/a?b=1&amp;c=2">docs</a> <a href="https://example.com">https://example.com</a> ` +
+				`<a href="mailto:ada@example.com">ada@example.com</a> <img src="https://example.com/l.png" alt="logo"></p>`,
+		},
+		{
+			name:     "Unsafe links keep their text",
+			markdown: `[click](javascript:alert(1)) ![x](data:image/png;base64,AAAA)`,
+			want:     "<p>click x</p>",
+		},
+		{
+			name:     "Raw HTML is stripped",
+			markdown: "<div onclick=\"x()\">\n<b>bold</b>\n</div>\n\n<script>\n\nalert(1)\n</script>\nText with <b>tags</b> and <img src=x onerror=y> & a < b",
+			want:     "<p>Text with tags and  &amp; a &lt; b</p>",
+		},
+		{
+			name:     "Escapes, entities and breaks",
+			markdown: "\\*not em\\* &copy; &lt;kept&gt;  \nnext\\\nlast",
+			want:     "<p>*not em* &copy; &lt;kept&gt;<br>\nnext<br>\nlast</p>",
+		},
+		{
+			name:     "Empty list item",
+			markdown: "text\n*",
+			want:     "<p>text\n*</p>",
+		},
+		{
+			name:     "Block quote and rule",
+			markdown: "> quoted\n> **text**\n\n***",
+			want:     "<blockquote>\n<p>quoted\n<strong>text</strong></p>\n</blockquote>\n<hr>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(Markdown(tt.markdown)); got != tt.want {
+				t.Errorf("Markdown() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// raceEnabled is set when the race detector is on, which slows rendering too
+// much for timing it to mean anything.
+var raceEnabled bool
+
+func TestMarkdownLargeInputIsLinear(t *testing.T) {
+	if raceEnabled {
+		t.Skip("Timing is meaningless under the race detector")
+	}
+	// Each pattern leaves delimiters, brackets or trailing spaces open, which
+	// a renderer searching again from every one of them takes seconds to render
+	for _, pattern := range []string{"*a ", "**a ", "_a ", "a \n", "a  \n", "[a ", "![a ", "[a](", "[a](b (", "[a](<"} {
+		source := strings.Repeat(pattern, 250000/len(pattern))
+		start := time.Now()
+		Markdown(source)
+		VisitorMarkdown(source)
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Rendering 250KB of %q took %v", pattern, elapsed)
+		}
+	}
+}
+
+func TestRenderEntry(t *testing.T) {
+	html := model.Email{Subject: "Hi", Message: "<p>As <b>written</b></p>"}
+	if err := RenderEntry(&html, ResolveLocale(), time.Now()); err != nil || html.Message != "<p>As <b>written</b></p>" {
+		t.Errorf("HTML without a template should be sent as written, got %q, %v", html.Message, err)
+	}
+
+	markdown := model.Email{Subject: "Hi", Message: "# News\n\n- <b>one</b>", ProductName: "Shop", Format: FormatMarkdown}
+	if err := RenderEntry(&markdown, ResolveLocale(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<h1>News</h1>", "<li>one</li>", "Sent by Shop"} {
+		if !strings.Contains(markdown.Message, want) {
+			t.Errorf("Markdown entry lacks %q:\n%s", want, markdown.Message)
+		}
+	}
+	if !strings.Contains(markdown.Text, "News\n====") {
+		t.Errorf("Expected a text version, got %q", markdown.Text)
+	}
+
+	text := model.Email{Subject: "Hi", Message: "a < b\nnext", Format: FormatText, Template: "card"}
+	if err := RenderEntry(&text, ResolveLocale(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.Message, "a &lt; b<br>\nnext") {
+		t.Errorf("Text entry should be escaped with its line breaks:\n%s", text.Message)
+	}
+}
+
+func TestMailMergeMarkdown(t *testing.T) {
+	merge, err := NewMailMerge("Hi {{.Name}}", "**{{.Name}}**, see {{.Link}}", FormatMarkdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, message, err := merge.Render(map[string]string{"Name": "Ada & <i>Co</i>", "Link": "<https://example.com>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<p><strong>Ada &amp; Co</strong>, see <a href="https://example.com">https://example.com</a></p>`
+	if got := string(Markdown(message)); got != want {
+		t.Errorf("Markdown(merged) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"slices"
+	texttemplate "text/template"
+	"text/template/parse"
+)
+
+// MailMerge renders one shared subject and message for many recipients.
+// The subject is plain text. An HTML message escapes every value for the
+// context it lands in, so recipients' data cannot inject markup; Markdown and
+// text messages are filled in as they are and escaped when rendered to HTML.
+type MailMerge struct {
+	subject   *texttemplate.Template
+	message   interface{ Execute(io.Writer, any) error }
+	variables []string
+}
+
+// NewMailMerge parses the subject and the message template, written in format,
+// and records which variables they reference.
+func NewMailMerge(subject, message, format string) (*MailMerge, error) {
+	subjectTmpl, err := texttemplate.New("subject").Option("missingkey=error").Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %v", err)
+	}
+
+	var messageTmpl interface{ Execute(io.Writer, any) error }
+	var messageTree *parse.Tree
+	if format == "" || format == FormatHTML {
+		tmpl, err := htmltemplate.New("message").Option("missingkey=error").Parse(message)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message template: %v", err)
+		}
+		messageTmpl, messageTree = tmpl, tmpl.Tree
+	} else {
+		tmpl, err := texttemplate.New("message").Option("missingkey=error").Parse(message)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message template: %v", err)
+		}
+		messageTmpl, messageTree = tmpl, tmpl.Tree
+	}
+
+	var variables []string
+	collectVariables(subjectTmpl.Tree.Root, true, &variables)
+	collectVariables(messageTree.Root, true, &variables)
+	slices.Sort(variables)
+
+	return &MailMerge{
+		subject:   subjectTmpl,
+		message:   messageTmpl,
+		variables: slices.Compact(variables),
+	}, nil
+}
+
+// Variables returns the sorted names of all variables referenced by the templates.
+func (m *MailMerge) Variables() []string {
+	return m.variables
+}
+
+// MissingVariables returns the referenced variables that data does not provide.
+func (m *MailMerge) MissingVariables(data map[string]string) []string {
+	var missing []string
+	for _, name := range m.variables {
+		if _, ok := data[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// Render fills both templates with one recipient's data.
+func (m *MailMerge) Render(data map[string]string) (subject, message string, err error) {
+	if data == nil {
+		data = map[string]string{}
+	}
+
+	var buf bytes.Buffer
+	if err = m.subject.Execute(&buf, data); err != nil {
+		return "", "", fmt.Errorf("failed to render subject: %v", err)
+	}
+	subject = buf.String()
+
+	buf.Reset()
+	if err = m.message.Execute(&buf, data); err != nil {
+		return "", "", fmt.Errorf("failed to render message: %v", err)
+	}
+	return subject, buf.String(), nil
+}
+
+// collectVariables walks a template tree and appends the names of top-level
+// fields, written as {{.FirstName}} or {{$.FirstName}}, also inside chains
+// such as {{(.Profile).City}} and the pipelines of if, range and with. In the
+// bodies of range and with blocks the dot no longer refers to the recipient's
+// data, so dotIsData is false there and only $ references are collected.
+func collectVariables(node parse.Node, dotIsData bool, variables *[]string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectVariables(child, dotIsData, variables)
+		}
+	case *parse.ActionNode:
+		collectVariables(n.Pipe, dotIsData, variables)
+	case *parse.IfNode:
+		collectVariables(n.Pipe, dotIsData, variables)
+		collectVariables(n.List, dotIsData, variables)
+		collectVariables(n.ElseList, dotIsData, variables)
+	case *parse.RangeNode:
+		collectVariables(n.Pipe, dotIsData, variables)
+		collectVariables(n.List, false, variables)
+		collectVariables(n.ElseList, dotIsData, variables)
+	case *parse.WithNode:
+		collectVariables(n.Pipe, dotIsData, variables)
+		collectVariables(n.List, false, variables)
+		collectVariables(n.ElseList, dotIsData, variables)
+	case *parse.TemplateNode:
+		collectVariables(n.Pipe, dotIsData, variables)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectVariables(cmd, dotIsData, variables)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectVariables(arg, dotIsData, variables)
+		}
+	case *parse.ChainNode:
+		collectVariables(n.Node, dotIsData, variables)
+	case *parse.FieldNode:
+		if dotIsData {
+			*variables = append(*variables, n.Ident[0])
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			*variables = append(*variables, n.Ident[1])
+		}
+	}
+}
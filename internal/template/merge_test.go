@@ -0,0 +1,83 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMailMergeVariables(t *testing.T) {
+	merge, err := NewMailMerge("Hi {{.FirstName}}", `<p>{{if .Company}}{{.Company}}{{end}} {{.FirstName}}</p>{{range .Items}}{{.Name}}{{end}}`, FormatHTML)
+	if err != nil {
+		t.Fatalf("NewMailMerge() error = %v", err)
+	}
+
+	want := []string{"Company", "FirstName", "Items"}
+	if got := merge.Variables(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Variables() = %v, want %v", got, want)
+	}
+
+	missing := merge.MissingVariables(map[string]string{"FirstName": "Ada"})
+	if !reflect.DeepEqual(missing, []string{"Company", "Items"}) {
+		t.Errorf("MissingVariables() = %v", missing)
+	}
+}
+
+func TestMailMergeVariableForms(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []string
+	}{
+		{name: "Root variable", message: `{{$.Name}}`, want: []string{"Name"}},
+		{name: "Root variable inside range", message: `{{range .Items}}{{.Title}} {{$.Name}}{{end}}`, want: []string{"Items", "Name"}},
+		{name: "With pipeline and body", message: `{{with .Company}}at {{.}} for {{$.Team}}{{else}}{{.Fallback}}{{end}}`, want: []string{"Company", "Fallback", "Team"}},
+		{name: "Chain", message: `{{(.Profile).City}}`, want: []string{"Profile"}},
+		{name: "Parenthesized and declared", message: `{{$n := .Name}}{{printf "%s %s" $n (.Surname)}}`, want: []string{"Name", "Surname"}},
+		{name: "Local variables are not data", message: `{{range $i, $item := .Items}}{{$item.Title}}{{end}}`, want: []string{"Items"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merge, err := NewMailMerge("Hi", tt.message, FormatText)
+			if err != nil {
+				t.Fatalf("NewMailMerge() error = %v", err)
+			}
+			if got := merge.Variables(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Variables() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMailMergeRenderEscapesData(t *testing.T) {
+	merge, err := NewMailMerge("Hello {{.Name}}", `<p>Hello {{.Name}}</p><a href="{{.Link}}">link</a>`, FormatHTML)
+	if err != nil {
+		t.Fatalf("NewMailMerge() error = %v", err)
+	}
+
+	subject, message, err := merge.Render(map[string]string{
+		"Name": "<b>Ada</b>",
+		"Link": "javascript:alert(1)",
+	})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	if subject != "Hello <b>Ada</b>" {
+		t.Errorf("subject = %q", subject)
+	}
+	wantMessage := `<p>Hello &lt;b&gt;Ada&lt;/b&gt;</p><a href="#ZgotmplZ">link</a>`
+	if message != wantMessage {
+		t.Errorf("message = %q, want %q", message, wantMessage)
+	}
+}
+
+func TestMailMergeRenderMissingKey(t *testing.T) {
+	merge, err := NewMailMerge("Hello {{.Name}}", "<p>Hi</p>", FormatHTML)
+	if err != nil {
+		t.Fatalf("NewMailMerge() error = %v", err)
+	}
+	if _, _, err := merge.Render(nil); err == nil {
+		t.Error("Expected an error when a variable is missing")
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package template
+
+func init() {
+	raceEnabled = true
+}
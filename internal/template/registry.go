@@ -0,0 +1,284 @@
+package template
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	texttemplate "text/template"
+	"time"
+)
+
+// DefaultContactTemplate renders contact form submissions unless another template is chosen.
+const DefaultContactTemplate = "card"
+
+// DefaultAutoReplyTemplate acknowledges submissions to their sender unless the form names another.
+const DefaultAutoReplyTemplate = "auto-reply"
+
+// ErrUnknownTemplate matches the error returned for a template that does not exist.
+var ErrUnknownTemplate = errors.New("unknown template")
+
+//go:embed templates/*
+var files embed.FS
+
+// templateName is what a template may be called: the file name before its
+// extension. A locale tag after the name, as in "card.de", makes the file a
+// variant of the template used for that locale.
+var templateName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*(\.[a-z]{2,3}(-[a-z0-9]{2,8})*)?$`)
+
+// emailTemplate is one template. The HTML body is escaped for the context
+// every value lands in, so submitted text can never add markup, links or
+// images to a message. The plain-text body and the subject are optional.
+type emailTemplate struct {
+	html    *htmltemplate.Template
+	text    *texttemplate.Template
+	subject *texttemplate.Template
+}
+
+// Message is a rendered email. Subject is empty when the template does not
+// define one, and Text is derived from the HTML when it has no text version.
+type Message struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// builtin holds the templates embedded from templates/, parsed once at startup.
+var builtin = mustParseBuiltin()
+
+// registry is the set of templates in use: the built-in ones plus those loaded
+// from TEMPLATE_DIR, which replace built-ins of the same name. It is swapped
+// as a whole on reload, so a render never sees half of a reload.
+var registry atomic.Pointer[map[string]*emailTemplate]
+
+func init() {
+	registry.Store(&builtin)
+}
+
+func mustParseBuiltin() map[string]*emailTemplate {
+	sub, err := fs.Sub(files, "templates")
+	if err != nil {
+		panic(err)
+	}
+	parsed, errs := parseTemplates(sub)
+	for name, err := range errs {
+		panic(fmt.Sprintf("template %q: %v", name, err)) // Embedded templates are fixed at build time
+	}
+	return parsed
+}
+
+// ContactData is what the contact form templates render: the submission
+// itself, when it was received, and its message as HTML in Body. Locale is
+// the tag the message is written in and T its labels, as in {{.T.name}}.
+// SubmittedAt is Submitted in the locale's date format.
+type ContactData struct {
+	*model.ContactForm
+	Submitted   Time
+	SubmittedAt string
+	Body        htmltemplate.HTML
+	Locale      string
+	T           map[string]string
+}
+
+// NewContactData prepares a submission received at for rendering in locale.
+// Times are shown in the timezone of at.
+func NewContactData(form *model.ContactForm, locale *Locale, at time.Time) ContactData {
+	return ContactData{
+		ContactForm: form,
+		Submitted:   NewTime(at, locale),
+		SubmittedAt: locale.FormatTime(at),
+		Body:        contactBody(form),
+		Locale:      locale.Tag,
+		T:           locale.Labels,
+	}
+}
+
+// contactBody is the message of a submission as HTML. It comes from a visitor,
+// so Markdown is rendered without links and images.
+func contactBody(form *model.ContactForm) htmltemplate.HTML {
+	if form.Format == FormatMarkdown {
+		return VisitorMarkdown(form.Message)
+	}
+	return FormatBody(FormatText, form.Message)
+}
+
+// sampleContact is the submission every template is rendered with when it is
+// loaded, so one referring to a field that does not exist is refused up front
+// instead of failing a visitor's message.
+var sampleContact = ContactData{
+	ContactForm: &model.ContactForm{
+		Name:           "Ada Lovelace",
+		Email:          "ada@example.com",
+		Subject:        "Question about your product",
+		Message:        "Hello,\nI would like to know more.",
+		ProductName:    "Example Product",
+		ProductWebsite: "https://example.com",
+		Template:       DefaultContactTemplate,
+	},
+	Submitted:   NewTime(time.Date(2006, time.January, 2, 15, 4, 0, 0, time.UTC), nil),
+	SubmittedAt: "Monday, 02 Jan 2006 15:04",
+	Body:        "<p>Hello,<br>\nI would like to know more.</p>",
+	Locale:      DefaultLocale,
+	T:           catalogs[DefaultLocale].Labels,
+}
+
+// SampleContact returns a copy of the submission templates are checked
+// with, for previews that must not show anybody's real message.
+func SampleContact() *model.ContactForm {
+	form := *sampleContact.ContactForm
+	return &form
+}
+
+// Check returns an error listing the available templates when name is not one of them.
+func Check(name string) error {
+	if strings.Contains(name, ".") || (*registry.Load())[name] == nil {
+		return fmt.Errorf("%w %q, available templates: %s", ErrUnknownTemplate, name, strings.Join(Names(), ", "))
+	}
+	return nil
+}
+
+// Render executes the named template with data, using its variant for the
+// most preferred tag in locale's chain that has one. A nil locale renders the
+// template itself.
+func Render(name string, locale *Locale, data any) (*Message, error) {
+	templates := *registry.Load()
+	if err := Check(name); err != nil {
+		return nil, err
+	}
+	tmpl := templates[name]
+	if locale != nil {
+		for _, tag := range locale.chain {
+			if variant := templates[name+"."+tag]; variant != nil {
+				name, tmpl = name+"."+tag, variant
+				break
+			}
+		}
+	}
+	message, err := tmpl.execute(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template %q: %v", name, err)
+	}
+	return message, nil
+}
+
+// Names returns the sorted names of the available templates, leaving out
+// their locale variants. A variant is only used alongside its template.
+func Names() []string {
+	var names []string
+	for name := range *registry.Load() {
+		if !strings.Contains(name, ".") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (t *emailTemplate) execute(data any) (*Message, error) {
+	var message Message
+	var buf bytes.Buffer
+	if err := t.html.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	message.HTML = buf.String()
+
+	if t.text != nil {
+		buf.Reset()
+		if err := t.text.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		message.Text = buf.String()
+	} else {
+		message.Text = HTMLToText(message.HTML)
+	}
+	if t.subject != nil {
+		buf.Reset()
+		if err := t.subject.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		// A subject is a single header line, whatever the file or the values hold
+		message.Subject = strings.Join(strings.Fields(buf.String()), " ")
+	}
+	return &message, nil
+}
+
+// parseTemplates reads every <name>.html in fsys, together with the optional
+// <name>.txt and <name>.subject beside it, and renders each template with
+// the sample submission. Templates that fail are left out and returned as
+// errors by name; other files are ignored.
+func parseTemplates(fsys fs.FS) (map[string]*emailTemplate, map[string]error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, map[string]error{".": err}
+	}
+
+	sources := make(map[string]map[string]string) // File contents by template name and extension
+	errs := make(map[string]error)
+	for _, entry := range entries {
+		ext := path.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if entry.IsDir() || !templateName.MatchString(name) || (ext != ".html" && ext != ".txt" && ext != ".subject") {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		if sources[name] == nil {
+			sources[name] = make(map[string]string)
+		}
+		sources[name][ext] = string(data)
+	}
+
+	parsed := make(map[string]*emailTemplate, len(sources))
+	for name, source := range sources {
+		if errs[name] != nil {
+			continue
+		}
+		tmpl, err := parseTemplate(name, source)
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		parsed[name] = tmpl
+	}
+	return parsed, errs
+}
+
+func parseTemplate(name string, source map[string]string) (*emailTemplate, error) {
+	html, ok := source[".html"]
+	if !ok {
+		return nil, fmt.Errorf("%s.html is missing", name)
+	}
+
+	var tmpl emailTemplate
+	var err error
+	html = cachedInlineCSS(html) // Styles written in <style> blocks move into the elements
+	if tmpl.html, err = htmltemplate.New(name + ".html").Option("missingkey=error").Funcs(funcs).Parse(html); err != nil {
+		return nil, err
+	}
+	if text, ok := source[".txt"]; ok {
+		if tmpl.text, err = texttemplate.New(name + ".txt").Option("missingkey=error").Funcs(funcs).Parse(text); err != nil {
+			return nil, err
+		}
+	}
+	if subject, ok := source[".subject"]; ok {
+		if tmpl.subject, err = texttemplate.New(name + ".subject").Option("missingkey=error").Funcs(funcs).Parse(subject); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tmpl.execute(sampleContact); err != nil {
+		return nil, fmt.Errorf("cannot render a sample submission: %v", err)
+	}
+	return &tmpl, nil
+}
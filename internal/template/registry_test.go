@@ -0,0 +1,72 @@
+package template
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryNames(t *testing.T) {
+	want := []string{"auto-reply", "banner", "card", "gradient", "message"}
+	if got := Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+	if _, err := Render("missing", nil, nil); err == nil {
+		t.Error("Expected an error for an unknown template")
+	}
+}
+
+func TestRenderEscapesSubmission(t *testing.T) {
+	form := &model.ContactForm{
+		Name:           `<img src="https://tracker.example/p.gif">`,
+		Email:          "ada@example.com",
+		Subject:        "Hi",
+		Message:        `<a href="https://evil.example">click</a>`,
+		ProductName:    "Shop",
+		ProductWebsite: "javascript:alert(1)",
+	}
+	for _, name := range Names() {
+		message, err := Render(name, nil, NewContactData(form, ResolveLocale(), time.Now()))
+		if err != nil {
+			t.Fatalf("Render(%q) error = %v", name, err)
+		}
+		html := message.HTML
+		for _, injected := range []string{"<img", `<a href="https://evil`, `href="javascript:`} {
+			if strings.Contains(html, injected) {
+				t.Errorf("Render(%q) let %q through", name, injected)
+			}
+		}
+		if name != DefaultLayout && !strings.Contains(html, "&lt;img") {
+			t.Errorf("Render(%q) should show the name as text", name)
+		}
+	}
+
+	message, _ := Render("card", nil, NewContactData(form, ResolveLocale(), time.Now()))
+	if !strings.Contains(message.HTML, `href="mailto:ada@example.com"`) {
+		t.Error("Expected the sender's address as a mailto link")
+	}
+}
+
+func TestContactMarkdownHasNoLinks(t *testing.T) {
+	form := &model.ContactForm{
+		Subject:     "Hi",
+		Message:     "**Hello** ![pixel](http://tracker.example/p.gif) [site](https://evil.example) <https://evil.example>",
+		Format:      FormatMarkdown,
+		ProductName: "Shop",
+	}
+	data := NewContactData(form, ResolveLocale(), time.Now())
+	want := `<p><strong>Hello</strong> pixel site https://evil.example</p>`
+	if got := string(data.Body); got != want {
+		t.Errorf("Body = %q, want %q", got, want)
+	}
+
+	email := model.Email{Subject: "Hi", Message: "![logo](https://example.com/logo.png)", Format: FormatMarkdown}
+	if err := RenderEntry(&email, ResolveLocale(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(email.Message, `<img src="https://example.com/logo.png" alt="logo">`) {
+		t.Errorf("Batch Markdown should keep its images:\n%s", email.Message)
+	}
+}
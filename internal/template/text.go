@@ -0,0 +1,556 @@
+package template
+
+import (
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// HTMLToText derives a readable plain-text version of an HTML email, for the
+// text/plain part sent alongside it. Paragraphs and headings are separated by
+// blank lines, links become "text (url)", lists get bullets or numbers, and
+// tables are laid out as aligned rows. Layout tables with a single column are
+// flattened, so the nested tables email layouts are built from read naturally.
+func HTMLToText(source string) string {
+	c := &textConverter{}
+	c.children(parseHTML(source))
+	return tidyText(c.out.String())
+}
+
+// htmlNode is an element, or a text node when tag is empty.
+type htmlNode struct {
+	tag      string
+	attrs    map[string]string
+	text     string
+	parent   *htmlNode
+	children []*htmlNode
+}
+
+// Elements that never have content or an end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// Elements whose content is not markup. It is skipped, except for textarea.
+var rawTextElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// Elements that start on a new line. Those in paragraphElements are also set
+// apart by a blank line.
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "center": true, "dd": true,
+	"div": true, "dl": true, "dt": true, "fieldset": true, "figcaption": true, "figure": true,
+	"footer": true, "form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "header": true, "hr": true, "li": true, "main": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "tr": true, "ul": true,
+}
+
+var paragraphElements = map[string]bool{
+	"blockquote": true, "dl": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "ol": true, "p": true, "pre": true, "table": true, "ul": true,
+}
+
+// Elements skipped with everything inside them.
+var hiddenElements = map[string]bool{"head": true, "script": true, "style": true, "template": true, "title": true}
+
+// parseHTML builds a tree from source, forgiving the way browsers are: end
+// tags without a start are ignored, unclosed elements end with their parent,
+// and list items, table cells and rows close the previous one.
+func parseHTML(source string) *htmlNode {
+	root := &htmlNode{tag: "#root"}
+	current := root
+	appendText := func(text string) {
+		if text != "" {
+			current.children = append(current.children, &htmlNode{text: html.UnescapeString(text), parent: current})
+		}
+	}
+
+	for len(source) > 0 {
+		lt := strings.IndexByte(source, '<')
+		if lt < 0 {
+			appendText(source)
+			break
+		}
+		appendText(source[:lt])
+		source = source[lt:]
+
+		switch {
+		case strings.HasPrefix(source, "<!--"):
+			end := strings.Index(source[4:], "-->")
+			if end < 0 {
+				return root
+			}
+			source = source[4+end+3:]
+		case strings.HasPrefix(source, "</") && len(source) > 2 && isASCIILetter(source[2]):
+			name, rest := tagName(source[2:])
+			source = skipPast(rest, '>')
+			for n := current; n != root; n = n.parent {
+				if n.tag == name {
+					current = n.parent
+					break
+				}
+			}
+		case strings.HasPrefix(source, "<!") || strings.HasPrefix(source, "<?"):
+			source = skipPast(source, '>') // Doctype or processing instruction
+		case len(source) > 1 && isASCIILetter(source[1]):
+			name, rest := tagName(source[1:])
+			attrs, selfClosing, rest := parseAttributes(rest)
+			source = rest
+
+			current = closeImplied(current, name)
+			element := &htmlNode{tag: name, attrs: attrs, parent: current}
+			current.children = append(current.children, element)
+			if rawTextElements[name] {
+				end := indexFold(source, "</"+name)
+				if end < 0 {
+					end = len(source)
+				}
+				element.children = []*htmlNode{{text: html.UnescapeString(source[:end]), parent: element}}
+				source = skipPast(source[end:], '>')
+				continue
+			}
+			if !selfClosing && !voidElements[name] {
+				current = element
+			}
+		default:
+			appendText("<")
+			source = source[1:]
+		}
+	}
+	return root
+}
+
+// closeImplied returns the element a new name element goes into, closing the
+// elements its start tag ends implicitly.
+func closeImplied(current *htmlNode, name string) *htmlNode {
+	closes := func(open string, stops ...string) {
+		for n := current; n.parent != nil; n = n.parent {
+			if n.tag == open {
+				current = n.parent
+				return
+			}
+			for _, stop := range stops {
+				if n.tag == stop {
+					return
+				}
+			}
+		}
+	}
+	switch name {
+	case "li":
+		closes("li", "ul", "ol")
+	case "dt", "dd":
+		closes("dt", "dl")
+		closes("dd", "dl")
+	case "td", "th":
+		closes("td", "tr", "table")
+		closes("th", "tr", "table")
+	case "tr":
+		closes("tr", "table")
+	}
+	if blockElements[name] && current.tag == "p" {
+		current = current.parent
+	}
+	return current
+}
+
+func isASCIILetter(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// tagName splits a lowercased tag name off the start of s.
+func tagName(s string) (string, string) {
+	end := strings.IndexAny(s, " \t\r\n\f/>")
+	if end < 0 {
+		end = len(s)
+	}
+	return strings.ToLower(s[:end]), s[end:]
+}
+
+// parseAttributes reads attributes up to the end of a start tag.
+func parseAttributes(s string) (attrs map[string]string, selfClosing bool, rest string) {
+	attrs = make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t\r\n\f")
+		switch {
+		case s == "":
+			return attrs, false, s
+		case s[0] == '>':
+			return attrs, false, s[1:]
+		case strings.HasPrefix(s, "/>"):
+			return attrs, true, s[2:]
+		case s[0] == '/':
+			s = s[1:]
+			continue
+		}
+
+		end := strings.IndexAny(s, " \t\r\n\f/>=")
+		if end < 0 {
+			end = len(s)
+		}
+		if end == 0 { // A stray "=" or quote
+			end = 1
+		}
+		name := strings.ToLower(s[:end])
+		s = strings.TrimLeft(s[end:], " \t\r\n\f")
+
+		var value string
+		if strings.HasPrefix(s, "=") {
+			s = strings.TrimLeft(s[1:], " \t\r\n\f")
+			if s != "" && (s[0] == '"' || s[0] == '\'') {
+				quote := s[0]
+				end := strings.IndexByte(s[1:], quote)
+				if end < 0 {
+					return attrs, false, ""
+				}
+				value, s = s[1:1+end], s[2+end:]
+			} else {
+				end := strings.IndexAny(s, " \t\r\n\f>")
+				if end < 0 {
+					end = len(s)
+				}
+				value, s = s[:end], s[end:]
+			}
+		}
+		if _, seen := attrs[name]; !seen {
+			attrs[name] = html.UnescapeString(value)
+		}
+	}
+}
+
+// skipPast returns s after the first c, or nothing when there is none.
+func skipPast(s string, c byte) string {
+	if i := strings.IndexByte(s, c); i >= 0 {
+		return s[i+1:]
+	}
+	return ""
+}
+
+// indexFold is strings.Index ignoring ASCII case.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Separators owed before the next text, from weakest to strongest.
+const (
+	sepNone = iota
+	sepSpace
+	sepLine
+	sepParagraph
+)
+
+// textConverter writes the text of a tree. Whitespace is collapsed outside
+// preformatted elements and separators are only written once text follows,
+// so empty layout elements leave no blank lines behind.
+type textConverter struct {
+	out strings.Builder
+	sep int
+	pre bool
+}
+
+func (c *textConverter) separate(sep int) {
+	if c.out.Len() > 0 && sep > c.sep {
+		c.sep = sep
+	}
+}
+
+func (c *textConverter) write(s string) {
+	if s == "" {
+		return
+	}
+	switch c.sep {
+	case sepSpace:
+		if !strings.HasSuffix(c.out.String(), "\n") {
+			c.out.WriteByte(' ')
+		}
+	case sepLine:
+		c.out.WriteString("\n")
+	case sepParagraph:
+		c.out.WriteString("\n\n")
+	}
+	c.sep = sepNone
+	c.out.WriteString(s)
+}
+
+func (c *textConverter) text(s string) {
+	if c.pre {
+		c.write(s)
+		return
+	}
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if s != "" {
+			c.separate(sepSpace)
+		}
+		return
+	}
+	if s[0] == ' ' || s[0] == '\t' || s[0] == '\n' || s[0] == '\r' {
+		c.separate(sepSpace)
+	}
+	for i, word := range words {
+		if i > 0 {
+			c.separate(sepSpace)
+		}
+		c.write(word)
+	}
+	if last := s[len(s)-1]; last == ' ' || last == '\t' || last == '\n' || last == '\r' {
+		c.separate(sepSpace)
+	}
+}
+
+// block writes text rendered on its own as a separate block.
+func (c *textConverter) block(text string, sep int) {
+	if text == "" {
+		return
+	}
+	c.separate(sep)
+	c.write(text)
+	c.separate(sep)
+}
+
+// render converts the children of n on their own, for elements that reshape their text.
+func (c *textConverter) render(n *htmlNode, pre bool) string {
+	sub := &textConverter{pre: c.pre || pre}
+	sub.children(n)
+	if sub.pre {
+		return strings.Trim(sub.out.String(), "\n")
+	}
+	return tidyText(sub.out.String())
+}
+
+func (c *textConverter) children(n *htmlNode) {
+	for _, child := range n.children {
+		c.node(child)
+	}
+}
+
+func (c *textConverter) node(n *htmlNode) {
+	if n.tag == "" {
+		c.text(n.text)
+		return
+	}
+	if hiddenElements[n.tag] {
+		return
+	}
+
+	sep := sepLine
+	if paragraphElements[n.tag] {
+		sep = sepParagraph
+	}
+	switch n.tag {
+	case "br":
+		c.write("\n")
+		c.sep = sepNone
+	case "hr":
+		c.block(strings.Repeat("-", 40), sepParagraph)
+	case "img":
+		c.text(n.attrs["alt"])
+	case "a":
+		c.write(linkText(c.render(n, false), n.attrs["href"]))
+	case "h1", "h2":
+		title := strings.Join(strings.Fields(c.render(n, false)), " ")
+		underline := "="
+		if n.tag == "h2" {
+			underline = "-"
+		}
+		if title != "" {
+			c.block(title+"\n"+strings.Repeat(underline, utf8.RuneCountInString(title)), sep)
+		}
+	case "ul", "ol":
+		if n.parent.tag == "li" {
+			sep = sepLine // Nested lists continue their item
+		}
+		c.block(c.list(n), sep)
+	case "blockquote":
+		c.block(prefixLines(c.render(n, false), "> ", "> "), sep)
+	case "table":
+		c.block(c.table(n), sep)
+	case "pre":
+		c.block(c.render(n, true), sep)
+	default:
+		pre := preformatted(n)
+		if blockElements[n.tag] || n.tag == "td" || n.tag == "th" {
+			c.block(c.render(n, pre), sep)
+		} else if pre && !c.pre {
+			c.write(c.render(n, true))
+		} else {
+			c.children(n)
+		}
+	}
+}
+
+// preformatted reports whether an element's style keeps its line breaks.
+func preformatted(n *htmlNode) bool {
+	style := strings.ReplaceAll(strings.ToLower(n.attrs["style"]), " ", "")
+	return strings.Contains(style, "white-space:pre")
+}
+
+// linkText writes a link as "text (url)", or as one of them when the other
+// adds nothing. Links to anchors and scripts keep their text only.
+func linkText(text, href string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	href = strings.TrimSpace(href)
+	lower := strings.ToLower(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(lower, "javascript:") {
+		return text
+	}
+	target := href
+	if strings.HasPrefix(lower, "mailto:") {
+		target = href[len("mailto:"):]
+	}
+	if text == "" || text == target || text == href {
+		return target
+	}
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(target, "https://"), "http://"), "/")
+	if text == trimmed {
+		return target
+	}
+	return text + " (" + target + ")"
+}
+
+// list numbers the items of an ol, or bullets those of a ul, indenting
+// their continuation lines under the first.
+func (c *textConverter) list(n *htmlNode) string {
+	var items []string
+	number := 1
+	if start, err := strconv.Atoi(n.attrs["start"]); err == nil {
+		number = start
+	}
+	for _, child := range n.children {
+		if child.tag != "li" {
+			continue
+		}
+		marker := "- "
+		if n.tag == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		text := c.render(child, false)
+		items = append(items, prefixLines(text, marker, strings.Repeat(" ", len(marker))))
+	}
+	return strings.Join(items, "\n")
+}
+
+// prefixLines puts first before the first line of text and rest before the others.
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		lines[i] = strings.TrimRight(prefix+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tableCell is a rendered cell, split into lines.
+type tableCell struct {
+	lines []string
+	span  int
+}
+
+// table lays rows out with every column as wide as its widest cell. Cells
+// spanning several columns do not widen them, so a long message in a full
+// width row does not push the columns apart.
+func (c *textConverter) table(n *htmlNode) string {
+	var rows [][]tableCell
+	columns := 0
+	var collect func(n *htmlNode)
+	collect = func(n *htmlNode) {
+		for _, child := range n.children {
+			switch child.tag {
+			case "thead", "tbody", "tfoot":
+				collect(child)
+			case "tr":
+				var row []tableCell
+				for _, cell := range child.children {
+					if cell.tag != "td" && cell.tag != "th" {
+						continue
+					}
+					span, err := strconv.Atoi(cell.attrs["colspan"])
+					if err != nil || span < 1 {
+						span = 1
+					}
+					text := c.render(cell, preformatted(cell))
+					row = append(row, tableCell{lines: strings.Split(text, "\n"), span: span})
+				}
+				rows = append(rows, row)
+				columns = max(columns, len(row))
+			}
+		}
+	}
+	collect(n)
+
+	if columns <= 1 {
+		// A layout table: its cells are just sections one after another
+		var blocks []string
+		for _, row := range rows {
+			for _, cell := range row {
+				if text := strings.Join(cell.lines, "\n"); text != "" {
+					blocks = append(blocks, text)
+				}
+			}
+		}
+		return strings.Join(blocks, "\n\n")
+	}
+
+	widths := make([]int, columns)
+	for _, row := range rows {
+		for i, cell := range row {
+			if cell.span > 1 || i == len(row)-1 {
+				continue // The last cell of a row is never padded
+			}
+			for _, line := range cell.lines {
+				widths[i] = max(widths[i], utf8.RuneCountInString(line))
+			}
+		}
+	}
+
+	var out []string
+	for _, row := range rows {
+		height := 0
+		for _, cell := range row {
+			if len(cell.lines) > 1 || cell.lines[0] != "" {
+				height = max(height, len(cell.lines))
+			}
+		}
+		for l := 0; l < height; l++ {
+			var line strings.Builder
+			for i, cell := range row {
+				var text string
+				if l < len(cell.lines) {
+					text = cell.lines[l]
+				}
+				line.WriteString(text)
+				if i < len(row)-1 && cell.span == 1 {
+					line.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(text)+2))
+				} else if i < len(row)-1 {
+					line.WriteString("  ")
+				}
+			}
+			out = append(out, strings.TrimRight(line.String(), " "))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// tidyText trims trailing spaces and leaves at most one blank line in a row.
+func tidyText(text string) string {
+	lines := strings.Split(text, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" && (len(kept) == 0 || kept[len(kept)-1] == "") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimRight(strings.Join(kept, "\n"), "\n")
+}
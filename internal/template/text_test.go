@@ -0,0 +1,96 @@
+package template
+
+import (
+	"Form-Mailly-Go/internal/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "Paragraphs and whitespace",
+			html: "<p>Hello\n   <b>world</b>!</p><p>Second&nbsp;one &amp; more</p>",
+			want: "Hello world!\n\nSecond one & more",
+		},
+		{
+			name: "Headings",
+			html: "<h1>Title</h1><h2>Part</h2><h3>Small</h3><p>Body</p>",
+			want: "Title\n=====\n\nPart\n----\n\nSmall\n\nBody",
+		},
+		{
+			name: "Links",
+			html: `<a href="https://example.com/docs">the docs</a>, <a href="https://example.com">example.com</a>, ` +
+				`<a href="mailto:ada@example.com">ada@example.com</a>, <a href="#top">top</a>, <a href="javascript:x()">js</a>`,
+			want: "the docs (https://example.com/docs), https://example.com, ada@example.com, top, js",
+		},
+		{
+			name: "Lists",
+			html: "<ul><li>One<li>Two<ol start=3><li>Three</li><li>Four</li></ol></ul>",
+			want: "- One\n- Two\n  3. Three\n  4. Four",
+		},
+		{
+			name: "Aligned table",
+			html: "<table><tr><th>Item</th><th>Qty</th></tr><tr><td>Apple</td><td>3</td></tr>" +
+				"<tr><td>Banana split</td><td>12</td></tr><tr><td colspan=2>A long note spanning both columns</td></tr></table>",
+			want: "Item          Qty\nApple         3\nBanana split  12\nA long note spanning both columns",
+		},
+		{
+			name: "Layout tables are flattened",
+			html: `<table><tr><td><table><tr><td><p>Header</p></td></tr><tr><td>Body</td></tr></table></td></tr></table>`,
+			want: "Header\n\nBody",
+		},
+		{
+			name: "Line breaks and preformatted text",
+			html: "Line<br>break<pre>  keep\n    this</pre><div style=\"white-space: pre-wrap\">a\nb</div>",
+			want: "Line\nbreak\n\n  keep\n    this\n\na\nb",
+		},
+		{
+			name: "Hidden content and comments",
+			html: "<html><head><title>T</title><style>p{color:red}</style></head><body><!-- note --><script>x()</script>" +
+				`<img src="logo.png" alt="Logo"> Text</body></html>`,
+			want: "Logo Text",
+		},
+		{
+			name: "Blockquote",
+			html: "<blockquote><p>Quoted</p><p>Twice</p></blockquote>",
+			want: "> Quoted\n>\n> Twice",
+		},
+		{
+			name: "Malformed markup",
+			html: "<p>a < b and <i>unclosed</p></span><p>next",
+			want: "a < b and unclosed\n\nnext",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTMLToText(tt.html); got != tt.want {
+				t.Errorf("HTMLToText() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderDerivesText(t *testing.T) {
+	form := &model.ContactForm{Name: "Ada", Email: "ada@example.com", Subject: "Hi", Message: "First line\nSecond line", ProductName: "Shop", ProductWebsite: "https://shop.example"}
+	for _, name := range []string{"banner", "card", "gradient"} {
+		message, err := Render(name, nil, NewContactData(form, ResolveLocale(), time.Now()))
+		if err != nil {
+			t.Fatalf("Render(%q) error = %v", name, err)
+		}
+		for _, want := range []string{"Ada", "ada@example.com", "First line", "Second line", "https://shop.example"} {
+			if !strings.Contains(message.Text, want) {
+				t.Errorf("Render(%q) text lacks %q:\n%s", name, want, message.Text)
+			}
+		}
+		if strings.Contains(message.Text, "<") {
+			t.Errorf("Render(%q) text contains markup:\n%s", name, message.Text)
+		}
+	}
+}
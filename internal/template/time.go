@@ -0,0 +1,73 @@
+package template
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time is a moment as templates see it: already in the timezone the message
+// is for, and printed the way its locale writes dates. The helpers in funcs
+// write it in other formats.
+type Time struct {
+	time.Time
+	locale *Locale
+}
+
+// NewTime returns t for templates rendered in locale.
+func NewTime(t time.Time, locale *Locale) Time {
+	return Time{Time: t, locale: locale}
+}
+
+// String writes the time in its locale's date format, as {{.Submitted}} does.
+func (t Time) String() string {
+	return t.locale.FormatTime(t.Time)
+}
+
+// now is the clock relative times are measured against.
+var now = time.Now
+
+// funcs are the helpers every template can call, such as {{iso .Submitted}}
+// or {{.Submitted | date "02/01/2006"}}.
+var funcs = map[string]any{
+	// iso writes the time in RFC 3339, such as 2025-03-03T14:35:00+05:30
+	"iso": func(t Time) string {
+		return t.Format(time.RFC3339)
+	},
+	// date writes the time with a time.Format layout, naming days and months in its locale
+	"date": func(layout string, t Time) string {
+		return t.locale.format(layout, t.Time)
+	},
+	// relative writes how long ago the time was, such as "5 minutes ago"
+	"relative": relative,
+	// in moves the time to another IANA timezone, such as {{in "Europe/Berlin" .Submitted}}
+	"in": func(zone string, t Time) (Time, error) {
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return Time{}, err
+		}
+		return Time{Time: t.In(location), locale: t.locale}, nil
+	},
+}
+
+// relative writes how long before now t was, in t's locale. Times in the future count as now.
+func relative(t Time) string {
+	elapsed := now().Sub(t.Time)
+	labels := t.locale.labels()
+	var key string
+	var n int
+	switch {
+	case elapsed < time.Minute:
+		return labels["relative_now"]
+	case elapsed < time.Hour:
+		key, n = "relative_minute", int(elapsed/time.Minute)
+	case elapsed < 24*time.Hour:
+		key, n = "relative_hour", int(elapsed/time.Hour)
+	default:
+		key, n = "relative_day", int(elapsed/(24*time.Hour))
+	}
+	if n > 1 {
+		key += "s"
+	}
+	return strings.ReplaceAll(labels[key], "{n}", strconv.Itoa(n))
+}
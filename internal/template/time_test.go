@@ -0,0 +1,63 @@
+package template
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeHelpers(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("no timezone database:", err)
+	}
+	at := time.Date(2025, time.March, 3, 9, 5, 0, 0, time.UTC).In(kolkata)
+
+	dir := t.TempDir()
+	t.Cleanup(func() { _ = LoadDir("") })
+	writeTemplateFile(t, dir, "times.html",
+		`<p>{{.Submitted}}|{{iso .Submitted}}|{{.Submitted | date "Mon 02/01 15:04 MST"}}|{{in "Europe/Berlin" .Submitted | iso}}</p>`)
+	if err := LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"en": "<p>Monday, 03 Mar 2025 14:35|2025-03-03T14:35:00&#43;05:30|Mon 03/03 14:35 IST|2025-03-03T10:05:00&#43;01:00</p>",
+		"de": "<p>Montag, 03. März 2025 14:35|2025-03-03T14:35:00&#43;05:30|Mon 03/03 14:35 IST|2025-03-03T10:05:00&#43;01:00</p>",
+	}
+	for tag, want := range tests {
+		locale := ResolveLocale(tag)
+		data := NewContactData(sampleContact.ContactForm, locale, at)
+		message, err := Render("times", locale, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.HTML != want {
+			t.Errorf("Render(times, %s) =\n%s\nwant\n%s", tag, message.HTML, want)
+		}
+	}
+}
+
+func TestRelative(t *testing.T) {
+	at := time.Date(2025, time.March, 3, 9, 5, 0, 0, time.UTC)
+	t.Cleanup(func() { now = time.Now })
+
+	tests := []struct {
+		elapsed time.Duration
+		tag     string
+		want    string
+	}{
+		{-time.Hour, "en", "just now"},
+		{30 * time.Second, "en", "just now"},
+		{time.Minute, "en", "1 minute ago"},
+		{59 * time.Minute, "en", "59 minutes ago"},
+		{5 * time.Hour, "de", "vor 5 Stunden"},
+		{25 * time.Hour, "fr", "hier"},
+		{72 * time.Hour, "es", "hace 3 días"},
+	}
+	for _, tt := range tests {
+		now = func() time.Time { return at.Add(tt.elapsed) }
+		if got := relative(NewTime(at, ResolveLocale(tt.tag))); got != tt.want {
+			t.Errorf("relative(%v, %s) = %q, want %q", tt.elapsed, tt.tag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package validation
+
+import "strings"
+
+// NormalizeEmail trims surrounding whitespace and lowercases the domain.
+// The local part is kept as typed, since RFC 5321 lets servers treat it as case-sensitive.
+func NormalizeEmail(address string) string {
+	address = strings.TrimSpace(address)
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return address
+	}
+	return address[:at+1] + strings.ToLower(address[at+1:])
+}
+
+// providerAliases maps domains that are the same mailbox provider to one canonical domain.
+var providerAliases = map[string]string{
+	"googlemail.com": "gmail.com",
+}
+
+// plusAddressingProviders ignore everything after a "+" in the local part.
+var plusAddressingProviders = map[string]bool{
+	"gmail.com":   true,
+	"outlook.com": true,
+	"hotmail.com": true,
+	"live.com":    true,
+	"icloud.com":  true,
+}
+
+// DedupeKey returns the identity used to detect duplicate recipients.
+// Addresses that differ only in case or surrounding whitespace share a key.
+// With foldProviders set, provider-specific aliases are folded as well:
+// Gmail ignores dots in the local part, and several providers ignore +tags.
+func DedupeKey(address string, foldProviders bool) string {
+	address = strings.ToLower(NormalizeEmail(address))
+	if !foldProviders {
+		return address
+	}
+
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return address
+	}
+	local, domain := address[:at], address[at+1:]
+
+	if alias, ok := providerAliases[domain]; ok {
+		domain = alias
+	}
+	if plusAddressingProviders[domain] {
+		if plus := strings.IndexByte(local, '+'); plus >= 0 {
+			local = local[:plus]
+		}
+	}
+	if domain == "gmail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	return local + "@" + domain
+}
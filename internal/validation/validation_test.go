@@ -111,3 +111,46 @@ func TestValidator_ValidateFieldAndIsValid(t *testing.T) {
 func strPtr(s string) *string {
 	return &s
 }
+
+func TestNormalizeEmail(t *testing.T) {
+	cases := map[string]struct {
+		input    string
+		expected string
+	}{
+		"Whitespace":        {"  john@example.com \n", "john@example.com"},
+		"Uppercase domain":  {"John@Example.COM", "John@example.com"},
+		"Missing @":         {" john ", "john"},
+		"Already canonical": {"jane@example.org", "jane@example.org"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := NormalizeEmail(tc.input); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDedupeKey(t *testing.T) {
+	cases := map[string]struct {
+		input         string
+		foldProviders bool
+		expected      string
+	}{
+		"Case only":                  {" John@Example.com", false, "john@example.com"},
+		"Gmail kept without folding": {"j.o.h.n+news@gmail.com", false, "j.o.h.n+news@gmail.com"},
+		"Gmail dots and tag":         {"J.o.h.n+news@GMail.com", true, "john@gmail.com"},
+		"Googlemail alias":           {"john@googlemail.com", true, "john@gmail.com"},
+		"Outlook tag, dots kept":     {"jo.hn+x@outlook.com", true, "jo.hn@outlook.com"},
+		"Other domain untouched":     {"jo.hn+x@example.com", true, "jo.hn+x@example.com"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := DedupeKey(tc.input, tc.foldProviders); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ package validation
 // Only the first encountered validation error is stored (fail-fast).
 type Validator struct {
 	Error string
+	Field string // Name of the field that produced Error
 }
 
 // NewValidator returns a new Validator with no errors.
@@ -24,6 +25,7 @@ func (v *Validator) ValidateField(field Field) {
 	for _, rule := range field.Rules {
 		if ok, msg := rule(field.Name, field.Value); !ok {
 			v.Error = msg // Record the first error encountered
+			v.Field = field.Name
 			return
 		}
 	}
@@ -0,0 +1,220 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// HeaderSignature carries "t=<unix seconds>,v1=<hex HMAC-SHA256>" on every delivery.
+	HeaderSignature = "X-FormMailly-Signature"
+
+	// maxBackoff caps the wait between two attempts, including a Retry-After asked by the receiver.
+	maxBackoff = time.Minute
+)
+
+// ErrInvalidSignature is returned by Verify when a delivery was not signed with the secret.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// ErrPrivateAddress is returned for callback URLs reaching the server itself
+// or its private network, which payloads are never sent to.
+var ErrPrivateAddress = errors.New("callback_url must point to a public address")
+
+// Sender POSTs signed JSON payloads, retrying with exponential backoff while
+// the receiver is unreachable or answers 408, 429 or 5xx.
+type Sender struct {
+	secret      string
+	maxAttempts int
+	client      *http.Client
+	backoff     func(attempt int) time.Duration
+	allowed     func(addr netip.Addr) bool // Addresses deliveries may connect to
+}
+
+// NewSender returns a sender signing with secret and giving up after maxAttempts.
+// Each attempt may take up to timeout. Deliveries only connect to public
+// addresses, checked once the host is resolved, so a host that passed
+// ValidateURL cannot later resolve into the server's own network. They do
+// not go through a proxy, which would connect on their behalf unchecked.
+func NewSender(secret string, maxAttempts int, timeout time.Duration) *Sender {
+	s := &Sender{
+		secret:      secret,
+		maxAttempts: max(1, maxAttempts),
+		backoff: func(attempt int) time.Duration {
+			return min(maxBackoff, time.Second<<(attempt-1))
+		},
+		allowed: publicAddress,
+	}
+	dialer := &net.Dialer{Timeout: timeout, Control: s.checkAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	s.client = &http.Client{Timeout: timeout, Transport: transport}
+	return s
+}
+
+// checkAddress refuses connections to addresses deliveries may not reach. It
+// runs for every connection, redirects included, with the resolved address.
+func (s *Sender) checkAddress(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !s.allowed(addrPort.Addr()) {
+		return fmt.Errorf("%w, not %s", ErrPrivateAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// ValidateURL checks that raw is an absolute http(s) URL a payload can be sent
+// to, and that its host only resolves to public addresses.
+func ValidateURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback_url must be an absolute http or https URL")
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("callback_url host %q cannot be resolved", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !publicAddress(addr) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// publicAddress reports whether addr is on the internet rather than the
+// loopback, a private or link-local network, or unspecified.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap() // ::ffff:127.0.0.1 is loopback too
+	return addr.IsValid() && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsUnspecified() &&
+		!addr.IsLinkLocalUnicast() && !addr.IsLinkLocalMulticast() && !addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast()
+}
+
+// Sign returns the signature header for body sent at timestamp. The HMAC
+// covers "<unix seconds>.<body>", so a captured delivery cannot be replayed
+// later with a fresh timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac(secret, t, body))
+}
+
+// Verify checks a signature header for body, rejecting deliveries older than
+// tolerance. Receivers written in Go can use it as is.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var t, v1 string
+	for part := range strings.SplitSeq(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			v1 = value
+		}
+	}
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+	signature, err := hex.DecodeString(v1)
+	if err != nil || !hmac.Equal(signature, mac(secret, t, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func mac(secret, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte{'.'})
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Deliver POSTs payload as JSON to target until the receiver answers 2xx, the
+// attempts run out or ctx ends. Cancelling ctx stops further retries but lets
+// the attempt in progress finish, so a shutdown still gets one delivery out.
+func (s *Sender) Deliver(ctx context.Context, target string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, wait, err := s.attempt(context.WithoutCancel(ctx), target, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.maxAttempts {
+			return fmt.Errorf("webhook delivery failed after %d attempt(s): %w", attempt, err)
+		}
+
+		if wait == 0 {
+			wait = s.backoff(attempt)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("webhook delivery stopped after %d attempt(s): %w", attempt, err)
+		}
+	}
+}
+
+// attempt makes one delivery. It reports whether a failure is worth retrying
+// and how long the receiver asked to wait, if it did.
+func (s *Sender) attempt(ctx context.Context, target string, body []byte) (retry bool, wait time.Duration, err error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "FormMaillyGo-Webhook")
+	request.Header.Set(HeaderSignature, Sign(s.secret, time.Now(), body))
+
+	response, err := s.client.Do(request)
+	if errors.Is(err, ErrPrivateAddress) {
+		return false, 0, err
+	}
+	if err != nil {
+		return true, 0, err // Network errors and timeouts are usually transient
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10)) // Lets the connection be reused
+	response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, 0, nil
+	}
+	err = fmt.Errorf("receiver answered %s", response.Status)
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable:
+		if seconds, convErr := strconv.Atoi(response.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+			wait = min(maxBackoff, time.Duration(seconds)*time.Second)
+		}
+		return true, wait, err
+	case response.StatusCode == http.StatusRequestTimeout || response.StatusCode >= 500:
+		return true, 0, err
+	}
+	return false, 0, err // Other 4xx answers will not change on a retry
+}
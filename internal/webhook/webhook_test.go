@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"batch_id":"abc"}`)
+	now := time.Unix(1700000000, 0)
+	header := Sign("secret", now, body)
+
+	if err := Verify("secret", header, body, 5*time.Minute, now.Add(time.Minute)); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+	if err := Verify("other", header, body, 5*time.Minute, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected a wrong secret to fail, got %v", err)
+	}
+	if err := Verify("secret", header, []byte(`{"batch_id":"xyz"}`), 5*time.Minute, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected a tampered body to fail, got %v", err)
+	}
+	if err := Verify("secret", header, body, 5*time.Minute, now.Add(time.Hour)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected an old delivery to fail, got %v", err)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	cases := map[string]struct {
+		statuses     []int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		"succeeds after server errors": {statuses: []int{503, 500, 200}, wantAttempts: 3},
+		"gives up after max attempts":  {statuses: []int{500, 500, 500, 500}, wantAttempts: 3, wantErr: true},
+		"client errors are final":      {statuses: []int{400, 200}, wantAttempts: 1, wantErr: true},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := attempts.Add(1)
+				body, _ := io.ReadAll(r.Body)
+				if err := Verify("secret", r.Header.Get(HeaderSignature), body, time.Minute, time.Now()); err != nil {
+					t.Errorf("attempt %d: %v", n, err)
+				}
+				w.WriteHeader(tc.statuses[n-1])
+			}))
+			defer server.Close()
+
+			sender := NewSender("secret", 3, time.Second)
+			sender.backoff = func(int) time.Duration { return time.Millisecond }
+			sender.allowed = func(netip.Addr) bool { return true } // The test server listens on the loopback
+
+			err := sender.Deliver(context.Background(), server.URL, map[string]string{"batch_id": "abc"})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Deliver() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got := attempts.Load(); got != tc.wantAttempts {
+				t.Errorf("Expected %d attempts, got %d", tc.wantAttempts, got)
+			}
+		})
+	}
+}
+
+func TestDeliverRefusesPrivateAddresses(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+	}))
+	defer server.Close()
+
+	sender := NewSender("secret", 3, time.Second)
+	sender.backoff = func(int) time.Duration { return time.Hour }
+
+	err := sender.Deliver(context.Background(), server.URL, map[string]string{"batch_id": "abc"})
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected the loopback to be refused when connecting, got %v", err)
+	}
+	if got := attempts.Load(); got != 0 {
+		t.Errorf("Expected no request to reach the server, got %d", got)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for raw, valid := range map[string]bool{
+		"https://93.184.215.14/hooks/batch": true,
+		"http://localhost:9000/cb":          false,
+		"http://127.0.0.1/cb":               false,
+		"http://[::1]/cb":                   false,
+		"http://[::ffff:10.0.0.1]/cb":       false,
+		"http://192.168.1.10/cb":            false,
+		"http://169.254.169.254/latest":     false,
+		"http://0.0.0.0:8080/cb":            false,
+		"ftp://example.com/":                false,
+		"/relative/path":                    false,
+		"https://":                          false,
+	} {
+		if err := ValidateURL(context.Background(), raw); (err == nil) != valid {
+			t.Errorf("ValidateURL(%q) = %v, want valid=%v", raw, err, valid)
+		}
+	}
+}